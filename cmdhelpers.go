@@ -1,7 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"webrecon/core"
@@ -10,20 +16,21 @@ import (
 )
 
 // ------------------------------- Variable Generators -------------------------------------------
+// generators return raw values, the CmdRunner shell quotes them when rendering a cmdline
 // -------------------------------- vars for initial recon
 func (p *Project) genOutfile(c *core.Cmd) string {
-	c.OutputFile = `'` + p.DataDir + `/` + c.Name + `-` + uuid.NewString() + `'`
+	c.OutputFile = p.DataDir + `/` + c.Name + `-` + uuid.NewString()
 	return c.OutputFile
 }
 
 func (p *Project) genRootDomsCSV(c *core.Cmd) string {
-	return `'` + strings.Join(p.RootDoms, ",") + `'`
+	return strings.Join(p.RootDoms, ",")
 }
 
 func (p *Project) genRootDomsFile(c *core.Cmd) string {
 	fname := p.DataDir + `/RootDoms-` + uuid.NewString()
 	core.WriteSliceToFile(p.RootDoms, fname)
-	return `'` + fname + `'`
+	return fname
 }
 
 // ------------------------------- Vars for flyover
@@ -37,16 +44,16 @@ func (p *Project) genAllFile(c *core.Cmd) string {
 	for key := range p.DNSMap {
 		a = append(a, key)
 	}
-	core.WriteSliceToFile(p.RootDoms, fname)
+	core.WriteSliceToFile(a, fname)
 	p.ResultsPath = fname
-	return `'` + fname + `'`
+	return fname
 }
 
 func (p *Project) genIPFile(c *core.Cmd) string {
 	fname := p.DataDir + `/ip-targets-` + uuid.NewString()
 	core.WriteSliceToFile(p.Scope.GetInScopeIPs(), fname)
 	p.ResultsPath = fname
-	return `'` + fname + `'`
+	return fname
 }
 
 func (p *Project) genDomsFile(c *core.Cmd) string {
@@ -57,11 +64,176 @@ func (p *Project) genDomsFile(c *core.Cmd) string {
 	}
 	core.WriteSliceToFile(d, fname)
 	p.ResultsPath = fname
-	return `'` + fname + `'`
+	return fname
 }
 
+// genOutputDir returns the aquatone dir, a chunk or foreach job gets a dir of its own in it, eg: aquatone/aquatone-2/
 func (p *Project) genOutputDir(c *core.Cmd) string {
-	return p.DataDir + `/` + `aquatone/`
+	dir := p.DataDir + `/` + `aquatone/`
+	if c.Parent != "" {
+		dir += strings.NewReplacer("[", "-", "]", "").Replace(c.Name) + `/`
+	}
+	return dir
+}
+
+//----------------------------------- vars defined in the config
+// config vars are built from recon.vars, see core.Var
+
+// configVar returns the VarFunc of a config var
+func (p *Project) configVar(name string, v core.Var) (core.VarFunc, error) {
+	switch {
+	case v.Env != "":
+		return func(c *core.Cmd) string {
+			if s := os.Getenv(v.Env); s != "" {
+				return s
+			}
+			return v.Default
+		}, nil
+	case v.Project != "":
+		if _, ok := p.projectField(v.Project); !ok {
+			return nil, errors.New("var " + name + ": unknown project field " + v.Project + ", use " + strings.Join(projectFields, ", "))
+		}
+		return func(c *core.Cmd) string {
+			s, _ := p.projectField(v.Project)
+			return s
+		}, nil
+	case v.Generate == core.GenTargets:
+		return func(c *core.Cmd) string {
+			return p.genTargets(name, v)
+		}, nil
+	case v.Generate == core.GenRootDoms && v.Format == "file":
+		return p.genRootDomsFile, nil
+	case v.Generate == core.GenRootDoms:
+		return p.genRootDomsCSV, nil
+	case v.Generate == core.GenOutput:
+		return func(c *core.Cmd) string {
+			c.OutputFile = p.DataDir + `/` + c.Name + `-` + uuid.NewString() + v.Ext
+			return c.OutputFile
+		}, nil
+	}
+	return func(c *core.Cmd) string {
+		return v.Value
+	}, nil
+}
+
+var projectFields = []string{"name", "data_dir", "max_threads", "scope", "excludes", "root_domains"}
+
+// projectField returns a field of the project as a string, lists are comma separated
+func (p *Project) projectField(field string) (string, bool) {
+	switch field {
+	case "name":
+		return p.Name, true
+	case "data_dir":
+		return p.DataDir, true
+	case "max_threads":
+		return strconv.Itoa(p.MaxThreads), true
+	case "scope":
+		return strings.Join(p.Scope.Ranges, ","), true
+	case "excludes":
+		return strings.Join(p.Scope.Excludes, ","), true
+	case "root_domains":
+		return strings.Join(p.RootDoms, ","), true
+	}
+	return "", false
+}
+
+// genTargets returns the targets of the project limited to the Type and Tag of a config var, in a file or as csv.
+// the targets are the in scope IPs and hosts found so far, or the urls found by parsers for type url.
+func (p *Project) genTargets(name string, v core.Var) string {
+	var targets []string
+	if v.Type == "" || v.Type == "ip" {
+		targets = append(targets, p.Scope.GetInScopeIPs()...)
+	}
+	p.mu.Lock()
+	for _, t := range p.Targets {
+		ip := net.ParseIP(t) != nil
+		if v.Type == "" || (v.Type == "ip" && ip) || (v.Type == "domain" && !ip) {
+			targets = append(targets, t)
+		}
+	}
+	if v.Type == "url" {
+		targets = append(targets, p.URLs...)
+	}
+	tags := p.targetTags()
+	p.mu.Unlock()
+	targets = core.UniqueSlice(targets)
+	if v.Tag != "" {
+		var tagged []string
+		for _, t := range targets {
+			if core.SliceContains(tags[t], v.Tag) || core.SliceContains(tags[urlHost(t)], v.Tag) {
+				tagged = append(tagged, t)
+			}
+		}
+		targets = tagged
+	}
+	sort.Strings(targets)
+	if v.Format == "csv" {
+		return strings.Join(targets, ",")
+	}
+	fname := p.DataDir + `/` + name + `-` + uuid.NewString()
+	core.WriteSliceToFile(targets, fname)
+	return fname
+}
+
+// targetTags returns the tags of the targets, from the results of parsers: port:N and proto:P for the
+// open ports of a host, severity:S and finding:NAME for the findings of a host or url. p.mu must be held.
+func (p *Project) targetTags() map[string][]string {
+	tags := make(map[string][]string)
+	add := func(target, tag string) {
+		if !core.SliceContains(tags[target], tag) {
+			tags[target] = append(tags[target], tag)
+		}
+	}
+	for _, port := range p.Ports {
+		add(port.Host, "port:"+strconv.Itoa(port.Port))
+		add(port.Host, "proto:"+port.Proto)
+	}
+	for _, f := range p.Findings {
+		for _, t := range []string{f.Target, urlHost(f.Target)} {
+			add(t, "finding:"+f.Name)
+			if f.Severity != "" {
+				add(t, "severity:"+f.Severity)
+			}
+		}
+	}
+	return tags
+}
+
+// urlHost returns the host of a url, or s when it is not a url
+func urlHost(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Hostname() == "" {
+		return s
+	}
+	return u.Hostname()
+}
+
+//----------------------------------- foreach items -------------------------------------------
+// items are read when a foreach command is ready to start, so they include what earlier commands found
+
+func (p *Project) itemDomains() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var d []string
+	for key := range p.DNSMap {
+		d = append(d, key)
+	}
+	sort.Strings(d)
+	return d
+}
+
+func (p *Project) itemIPs() []string {
+	return p.Scope.GetInScopeIPs()
+}
+
+func (p *Project) itemTargets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return core.UniqueSlice(p.Targets)
+}
+
+func (p *Project) itemRootDoms() []string {
+	return p.RootDoms
 }
 
 //----------------------------------- runner callbacks -------------------------------------------
@@ -76,16 +248,78 @@ func (p *Project) aqCallback(c core.Cmd) error {
 }
 
 func (p *Project) domainsCallback(c core.Cmd) error {
-	var wgDoms = new(sync.WaitGroup)
-	var wgDomCnt int
-	const maxResolves = 5
-	var mutex = &sync.Mutex{}
-
-	doms, err := core.ReadLines(strings.ReplaceAll(c.OutputFile, "'", ""))
+	doms, err := core.ReadLines(c.OutputFile)
 	if err != nil {
 		core.Eprint(err)
 		return err
 	}
+	p.resolveDomains(doms)
+	return nil
+}
+
+// resultsCallback adds the results parsed from the output of a command to the project, domains and ips are
+// scope checked, and ports are kept for the hosts that are in scope.
+func (p *Project) resultsCallback(c core.Cmd, res core.Results) error {
+	doms := res.Domains
+	ips := res.IPs
+	for _, port := range res.Ports {
+		if net.ParseIP(port.Host) != nil {
+			ips = append(ips, port.Host)
+		} else {
+			doms = append(doms, port.Host)
+		}
+	}
+	p.resolveDomains(doms)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ip := range core.UniqueSlice(ips) {
+		if net.ParseIP(ip) != nil && p.Scope.IsIPInscope(ip) && !core.SliceContains(p.Targets, ip) {
+			p.Targets = append(p.Targets, ip)
+		}
+	}
+	for _, port := range res.Ports {
+		if core.SliceContains(p.Targets, port.Host) && !portsContain(p.Ports, port) {
+			p.Ports = append(p.Ports, port)
+		}
+	}
+	for _, u := range res.URLs {
+		if !core.SliceContains(p.URLs, u) {
+			p.URLs = append(p.URLs, u)
+		}
+	}
+	for _, f := range res.Findings {
+		if !findingsContain(p.Findings, f) {
+			p.Findings = append(p.Findings, f)
+		}
+	}
+	core.Dprint(c.Name, "parsed", len(res.Domains), "domains,", len(res.IPs), "ips,", len(res.Ports), "ports,", len(res.URLs), "urls,", len(res.Findings), "findings")
+	return nil
+}
+
+func portsContain(ports []core.Port, port core.Port) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func findingsContain(findings []core.Finding, f core.Finding) bool {
+	for _, i := range findings {
+		if i == f {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDomains resolves and scope checks domains, a few at a time
+func (p *Project) resolveDomains(doms []string) {
+	var wgDoms = new(sync.WaitGroup)
+	var wgDomCnt int
+	const maxResolves = 5
+
 	doms = core.UniqueSlice(doms)
 	wgDomCnt = 0
 	for _, dom := range doms {
@@ -99,17 +333,47 @@ func (p *Project) domainsCallback(c core.Cmd) error {
 		// go func routine for parrallel resolve in ParseDomains
 		go func(dom string, p *Project) {
 			defer wgDoms.Done()
-			core.Dprint("resolving:", dom)
-			ok, ips := p.Scope.IsDNSInScope(dom)
-			if ok {
-				mutex.Lock()
-				p.Targets = append(p.Targets, dom)
-				p.DNSMap[dom] = append(p.DNSMap[dom], ips...)
-				mutex.Unlock()
-			}
+			p.resolveDomain(dom)
 			wgDomCnt--
 		}(dom, p)
 	}
 	wgDoms.Wait()
-	return nil
+}
+
+// resolveDomain adds a domain to the targets if it resolves to an in scope IP, domains that are already targets are skipped.
+func (p *Project) resolveDomain(dom string) bool {
+	p.mu.Lock()
+	known := core.SliceContains(p.Targets, dom)
+	p.mu.Unlock()
+	if known {
+		return true
+	}
+	core.Dprint("resolving:", dom)
+	ok, ips := p.Scope.IsDNSInScope(dom)
+	if ok {
+		p.mu.Lock()
+		p.Targets = append(p.Targets, dom)
+		p.DNSMap[dom] = core.UniqueSlice(append(p.DNSMap[dom], ips...))
+		p.mu.Unlock()
+	}
+	return ok
+}
+
+//----------------------------------- line callbacks -------------------------------------------
+// line callbacks are called with every line a command outputs while it runs
+
+// domainsLineCallback resolves and scope checks every domain as soon as a tool prints it
+func (p *Project) domainsLineCallback(c core.Cmd, stream string, line string) {
+	dom := strings.TrimSpace(line)
+	if stream != core.StreamStdout || dom == "" || strings.ContainsAny(dom, " \t/:") {
+		return
+	}
+	if p.resolveDomain(dom) {
+		fmt.Printf("[%s] in scope: %s\n", c.Name, dom)
+	}
+}
+
+// progressLineCallback prints the output of a command live
+func (p *Project) progressLineCallback(c core.Cmd, stream string, line string) {
+	fmt.Printf("[%s] %s\n", c.Name, line)
 }
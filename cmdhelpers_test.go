@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"webrecon/core"
+)
+
+// newTestProject returns a project with a few targets and parsed results, in a temporary data dir
+func newTestProject(t *testing.T) *Project {
+	return &Project{
+		Name:       "p1",
+		DataDir:    t.TempDir(),
+		MaxThreads: 4,
+		Scope:      core.Scope{Ranges: core.IPs{"10.0.0.1-3"}, Excludes: core.IPs{"10.0.0.2"}},
+		RootDoms:   []string{"a.com", "b.com"},
+		Targets:    []string{"10.0.0.5", "www.a.com", "b.com", "10.0.0.1"},
+		URLs:       []string{"https://www.a.com/.git", "http://b.com/"},
+		Ports:      []core.Port{{Host: "www.a.com", Port: 443, Proto: "tcp"}, {Host: "10.0.0.5", Port: 53, Proto: "udp"}},
+		Findings:   []core.Finding{{Target: "https://www.a.com/.git", Name: "exposed-git", Severity: "high"}},
+		mu:         new(sync.Mutex),
+	}
+}
+
+func TestConfigVar(t *testing.T) {
+	t.Setenv("WR_TEST_SET", "s3cret")
+	t.Setenv("WR_TEST_EMPTY", "")
+	tests := []struct {
+		name string
+		v    core.Var
+		want string
+	}{
+		{"value", core.Var{Value: "/usr/share/words"}, "/usr/share/words"},
+		{"env", core.Var{Env: "WR_TEST_SET", Default: "x"}, "s3cret"},
+		{"env default", core.Var{Env: "WR_TEST_EMPTY", Default: "x"}, "x"},
+		{"env unset", core.Var{Env: "WR_TEST_UNSET"}, ""},
+		{"project name", core.Var{Project: "name"}, "p1"},
+		{"project max_threads", core.Var{Project: "max_threads"}, "4"},
+		{"project scope", core.Var{Project: "scope"}, "10.0.0.1-3"},
+		{"project excludes", core.Var{Project: "excludes"}, "10.0.0.2"},
+		{"project root_domains", core.Var{Project: "root_domains"}, "a.com,b.com"},
+		{"rootdoms csv", core.Var{Generate: core.GenRootDoms}, "a.com,b.com"},
+		{"targets csv", core.Var{Generate: core.GenTargets, Format: "csv", Type: "domain"}, "b.com,www.a.com"},
+	}
+	for _, tt := range tests {
+		p := newTestProject(t)
+		vf, err := p.configVar("V", tt.v)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := vf(&core.Cmd{Name: "t"}); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	p := newTestProject(t)
+	if vf, err := p.configVar("V", core.Var{Project: "data_dir"}); err != nil || vf(&core.Cmd{}) != p.DataDir {
+		t.Errorf("data_dir: %v", err)
+	}
+	if _, err := p.configVar("V", core.Var{Project: "nope"}); err == nil ||
+		err.Error() != "var V: unknown project field nope, use name, data_dir, max_threads, scope, excludes, root_domains" {
+		t.Errorf("unknown project field: %v", err)
+	}
+	// files are written to the data dir
+	for _, v := range []core.Var{{Generate: core.GenRootDoms, Format: "file"}, {Generate: core.GenTargets, Type: "domain"}} {
+		vf, _ := p.configVar("V", v)
+		path := vf(&core.Cmd{Name: "t"})
+		lines, err := core.ReadLines(path)
+		if err != nil || !strings.HasPrefix(path, p.DataDir+"/") || len(lines) != 2 {
+			t.Errorf("%+v: file %s lines %q error %v", v, path, lines, err)
+		}
+	}
+	// an output var names a new file for every Cmd and sets its OutputFile
+	vf, _ := p.configVar("Out", core.Var{Generate: core.GenOutput, Ext: ".json"})
+	cmd := core.Cmd{Name: "nuclei"}
+	path := vf(&cmd)
+	if path != cmd.OutputFile || !strings.HasPrefix(path, p.DataDir+"/nuclei-") || !strings.HasSuffix(path, ".json") || vf(&cmd) == path {
+		t.Errorf("output file %s, cmd output file %s", path, cmd.OutputFile)
+	}
+}
+
+func TestGenTargets(t *testing.T) {
+	tests := []struct {
+		typ  string
+		tag  string
+		want string
+	}{
+		{"", "", "10.0.0.1,10.0.0.3,10.0.0.5,b.com,www.a.com"},
+		{"ip", "", "10.0.0.1,10.0.0.3,10.0.0.5"},
+		{"domain", "", "b.com,www.a.com"},
+		{"url", "", "http://b.com/,https://www.a.com/.git"},
+		{"", "port:443", "www.a.com"},
+		{"", "proto:udp", "10.0.0.5"},
+		{"ip", "port:443", ""},
+		{"domain", "severity:high", "www.a.com"},
+		{"url", "severity:high", "https://www.a.com/.git"},
+		{"url", "finding:exposed-git", "https://www.a.com/.git"},
+		{"", "severity:low", ""},
+	}
+	for _, tt := range tests {
+		p := newTestProject(t)
+		got := p.genTargets("T", core.Var{Generate: core.GenTargets, Type: tt.typ, Tag: tt.tag, Format: "csv"})
+		if got != tt.want {
+			t.Errorf("type %q tag %q: %q, want %q", tt.typ, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestProjectField(t *testing.T) {
+	p := newTestProject(t)
+	for _, field := range projectFields {
+		if _, ok := p.projectField(field); !ok {
+			t.Errorf("projectFields lists %s, which projectField does not know", field)
+		}
+	}
+	if s, ok := p.projectField("Name"); ok {
+		t.Errorf("field Name is %q, fields are lowercase", s)
+	}
+}
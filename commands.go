@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"webrecon/core"
+)
+
+// cmdInit creates the data directory for a new project, and writes the project file when -project
+// points at a file that does not exist yet.
+func cmdInit(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	if code, ok := parseFlags(f, pf, args, 0); !ok {
+		return code
+	}
+	newFile := ""
+	if pf.ProjectPath != "" {
+		if _, err := os.Stat(pf.ProjectPath); os.IsNotExist(err) {
+			newFile = pf.ProjectPath
+			pf.ProjectPath = ""
+		}
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	err = core.MakeDir(p.DataDir)
+	if err != nil {
+		return fail(err)
+	}
+	if newFile != "" {
+		err = p.WriteProjectFile(newFile)
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Println("wrote project file", newFile)
+	}
+	fmt.Println("initialized project", p.Name, "in", p.DataDir)
+	return exitOK
+}
+
+// cmdRun runs recon against the project
+func cmdRun(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	restart := f.Bool("restart", false, "start a new run instead of resuming an interrupted one")
+	dryRun := f.Bool("dry-run", false, "print the rendered commands of every stage and check them, without running anything")
+	noCache := f.Bool("no-cache", false, "run every command again instead of reusing results cached within its cache_ttl")
+	if code, ok := parseFlags(f, pf, args, 0); !ok {
+		return code
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	p.Restart = *restart
+	p.DryRun = *dryRun
+	p.NoCache = *noCache
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = p.StartRecon(ctx)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "interrupted:", err)
+		return exitInterrupted
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if p.DryRun {
+		return exitOK
+	}
+	fmt.Println("recon complete,", len(p.DNSMap), "hostnames mapped, results saved to", p.statePath())
+	return exitOK
+}
+
+// cmdStatus prints the project configuration, and a summary of the saved results
+func cmdStatus(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	if code, ok := parseFlags(f, pf, args, 0); !ok {
+		return code
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Println("project:     ", p.Name)
+	fmt.Println("data dir:    ", p.DataDir)
+	fmt.Println("scope:       ", strings.Join(p.Scope.Ranges, ", "))
+	fmt.Println("excludes:    ", strings.Join(p.Scope.Excludes, ", "))
+	fmt.Println("root domains:", strings.Join(p.RootDoms, ", "))
+	fmt.Println("max threads: ", p.MaxThreads)
+	fmt.Println("in scope IPs:", len(p.Scope.GetInScopeIPs()))
+	fmt.Println()
+	for _, st := range c.GetStages() {
+		mode := st.Mode
+		if mode == "" {
+			mode = core.ModeParallel
+		}
+		fmt.Printf("%s (%s):\n", st.Name, mode)
+		for _, i := range st.Runners {
+			fmt.Println("  -", i.Name)
+		}
+	}
+	fmt.Println()
+	err = p.LoadState()
+	if os.IsNotExist(err) {
+		fmt.Println("no results yet, use \"webrecon run\"")
+		return exitOK
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if p.state.Complete {
+		fmt.Println("last run:    ", p.state.Started.Format(time.RFC3339), "complete")
+	} else {
+		fmt.Println("last run:    ", p.state.Started.Format(time.RFC3339), "interrupted, \"webrecon run\" will resume it")
+	}
+	fmt.Println("targets:     ", len(p.Targets))
+	fmt.Println("hostnames:   ", len(p.DNSMap))
+	fmt.Println("open ports:  ", len(p.Ports))
+	fmt.Println("urls:        ", len(p.URLs))
+	fmt.Println("findings:    ", len(p.Findings))
+	var tools []string
+	for name := range p.state.Tools {
+		tools = append(tools, name)
+	}
+	sort.Strings(tools)
+	for _, name := range tools {
+		t := p.state.Tools[name]
+		fmt.Printf("  tool %-14s %s %s\n", name, t.Path, t.Version)
+	}
+	var keys []string
+	for k := range p.state.Cmds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("  %-40s %s\n", k, p.state.Cmds[k].Status)
+	}
+	return exitOK
+}
+
+// cmdScope prints every in scope IP, one per line
+func cmdScope(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	if code, ok := parseFlags(f, pf, args, 0); !ok {
+		return code
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	for _, ip := range p.Scope.GetInScopeIPs() {
+		fmt.Println(ip)
+	}
+	return exitOK
+}
+
+// cmdExport writes the saved results as json, csv or a plain list of targets
+func cmdExport(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	format := f.String("format", "json", "output format: json, csv or txt")
+	outPath := f.String("o", "", "write to a file instead of stdout")
+	if code, ok := parseFlags(f, pf, args, 0); !ok {
+		return code
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	err = p.LoadState()
+	if err != nil {
+		return fail("no results to export:", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		fh, err := os.Create(*outPath)
+		if err != nil {
+			return fail(err)
+		}
+		defer fh.Close()
+		out = fh
+	}
+
+	err = p.exportResults(out, *format)
+	if err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func (p *Project) exportResults(out io.Writer, format string) error {
+	var doms []string
+	for dom := range p.DNSMap {
+		doms = append(doms, dom)
+	}
+	sort.Strings(doms)
+
+	switch format {
+	case "json":
+		e := json.NewEncoder(out)
+		e.SetIndent("", "  ")
+		return e.Encode(struct {
+			Name     string         `json:"name"`
+			Targets  []string       `json:"targets"`
+			DNSMap   DNStoIPMap     `json:"dns_map"`
+			Ports    []core.Port    `json:"ports,omitempty"`
+			URLs     []string       `json:"urls,omitempty"`
+			Findings []core.Finding `json:"findings,omitempty"`
+		}{p.Name, p.Targets, p.DNSMap, p.Ports, p.URLs, p.Findings})
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"hostname", "ips"})
+		for _, dom := range doms {
+			w.Write([]string{dom, strings.Join(p.DNSMap[dom], " ")})
+		}
+		w.Flush()
+		return w.Error()
+	case "txt":
+		for _, dom := range doms {
+			fmt.Fprintln(out, dom)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// cmdRuns lists the recorded runs of the project
+func cmdRuns(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	if code, ok := parseFlags(f, pf, args, 0); !ok {
+		return code
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	runs, err := p.LoadRuns()
+	if err != nil {
+		return fail(err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("no recorded runs, use \"webrecon run\"")
+		return exitOK
+	}
+	writeRuns(os.Stdout, runs)
+	return exitOK
+}
+
+// writeRuns writes a table of runs to out
+func writeRuns(out io.Writer, runs []projectState) {
+	fmt.Fprintf(out, "%-18s %-26s %-12s %10s %10s\n", "RUN", "STARTED", "STATUS", "HOSTNAMES", "TARGETS")
+	for _, r := range runs {
+		status := "complete"
+		if !r.Complete {
+			status = "interrupted"
+		}
+		fmt.Fprintf(out, "%-18s %-26s %-12s %10d %10d\n", r.RunID, r.Started.Format(time.RFC3339), status, len(r.DNSMap), len(r.Targets))
+	}
+}
+
+// cmdDiff prints the assets that were added, removed or changed between two runs
+func cmdDiff(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	format := f.String("format", "txt", "output format: txt or json")
+	if code, ok := parseFlags(f, pf, args, 2); !ok {
+		return code
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	a, err := p.LoadRun(f.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	b, err := p.LoadRun(f.Arg(1))
+	if err != nil {
+		return fail(err)
+	}
+	if err := writeDiff(os.Stdout, *format, a, b); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+// writeDiff writes the assets that changed from run a to run b to out, as txt or json
+func writeDiff(out io.Writer, format string, a, b projectState) error {
+	d := diffRuns(a, b)
+	switch format {
+	case "json":
+		e := json.NewEncoder(out)
+		e.SetIndent("", "  ")
+		return e.Encode(d)
+	case "txt":
+		fmt.Fprintln(out, "run", d.From, "->", d.To)
+		fmt.Fprintf(out, "\nadded domains (%d):\n", len(d.AddedDomains))
+		for _, i := range d.AddedDomains {
+			fmt.Fprintln(out, "  +", i, strings.Join(b.DNSMap[i], " "))
+		}
+		fmt.Fprintf(out, "\nremoved domains (%d):\n", len(d.RemovedDomains))
+		for _, i := range d.RemovedDomains {
+			fmt.Fprintln(out, "  -", i, strings.Join(a.DNSMap[i], " "))
+		}
+		fmt.Fprintf(out, "\nchanged resolutions (%d):\n", len(d.ChangedIPs))
+		for _, i := range d.ChangedIPs {
+			fmt.Fprintln(out, "  ~", i.Name, strings.Join(i.From, " "), "->", strings.Join(i.To, " "))
+		}
+		fmt.Fprintf(out, "\nnew in scope hosts (%d):\n", len(d.NewTargets))
+		for _, i := range d.NewTargets {
+			fmt.Fprintln(out, "  +", i)
+		}
+	default:
+		return fmt.Errorf("unknown diff format %q", format)
+	}
+	return nil
+}
+
+// cmdJournal prints the journal of executed commands as text, or exports it as jsonl or csv
+func cmdJournal(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	format := f.String("format", "txt", "output format: txt, jsonl or csv")
+	outPath := f.String("o", "", "write to a file instead of stdout")
+	runID := f.String("run", "", "only show the commands of this run id, or \"latest\" or \"previous\"")
+	if code, ok := parseFlags(f, pf, args, 0); !ok {
+		return code
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	entries, err := core.ReadJournal(p.journalPath())
+	if err != nil {
+		return fail("failed to read journal:", err)
+	}
+	if *runID == "latest" || *runID == "previous" {
+		r, err := p.LoadRun(*runID)
+		if err != nil {
+			return fail(err)
+		}
+		*runID = r.RunID
+	}
+	if *runID != "" {
+		var filtered []core.JournalEntry
+		for _, e := range entries {
+			if e.RunID == *runID {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		fh, err := os.Create(*outPath)
+		if err != nil {
+			return fail(err)
+		}
+		defer fh.Close()
+		out = fh
+	}
+
+	if err := writeJournal(out, *format, entries); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+// writeJournal writes journal entries to out as txt, jsonl or csv
+func writeJournal(out io.Writer, format string, entries []core.JournalEntry) error {
+	switch format {
+	case "jsonl":
+		e := json.NewEncoder(out)
+		for _, i := range entries {
+			if err := e.Encode(i); err != nil {
+				return err
+			}
+		}
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"run_id", "stage", "name", "attempt", "started", "finished", "duration_seconds", "exit_code", "status", "cached", "cmdline", "output_file", "callback", "callback_result", "operator", "host"})
+		for _, i := range entries {
+			w.Write([]string{i.RunID, i.Stage, i.Name, fmt.Sprint(i.Attempt), i.Started.Format(time.RFC3339), i.Finished.Format(time.RFC3339),
+				fmt.Sprintf("%.3f", i.Duration), fmt.Sprint(i.ExitCode), i.Status, fmt.Sprint(i.Cached), i.CmdLine, i.OutputFile, i.CallBack, i.CallBackResult, i.Operator, i.Host})
+		}
+		w.Flush()
+		return w.Error()
+	case "txt":
+		for _, i := range entries {
+			status := i.Status
+			if i.Cached {
+				status += " (cached)" // the result was reused, the command did not run
+			}
+			fmt.Fprintf(out, "%s  %s  %-30s #%d %-18s exit %-3d %8.1fs  %s@%s\n    %s\n",
+				i.Started.Format(time.RFC3339), i.RunID, i.Stage+"/"+i.Name, i.Attempt, status, i.ExitCode, i.Duration, i.Operator, i.Host, i.CmdLine)
+		}
+	default:
+		return fmt.Errorf("unknown journal format %q", format)
+	}
+	return nil
+}
+
+// cmdCtl sends an action to the control socket of a running project, and prints the state of its current stage
+func cmdCtl(f *flag.FlagSet, pf *projectFlags, args []string) int {
+	if code, ok := parseFlags(f, pf, args, -1); !ok {
+		return code
+	}
+	p, err := loadProject(pf)
+	if err != nil {
+		return fail(err)
+	}
+	req := ctlRequest{Action: f.Arg(0)}
+	nargs := map[string]int{"status": 1, "pause": 1, "resume": 1, "cancel": 2, "priority": 3, "add": 2}
+	n, ok := nargs[req.Action]
+	if !ok || f.NArg() != n {
+		f.Usage()
+		return exitUsage
+	}
+	switch req.Action {
+	case "cancel", "priority":
+		req.QID, err = strconv.Atoi(f.Arg(1))
+		if err != nil {
+			return fail("invalid QID", f.Arg(1))
+		}
+		if req.Action == "priority" {
+			req.Priority, err = strconv.Atoi(f.Arg(2))
+			if err != nil {
+				return fail("invalid priority", f.Arg(2))
+			}
+		}
+	case "add":
+		req.Cmd = f.Arg(1)
+	}
+	resp, err := p.sendControl(req)
+	if err != nil {
+		return fail(err)
+	}
+	if resp.Error != "" && resp.Stage == "" {
+		return fail(resp.Error)
+	}
+	state := "running"
+	if resp.Paused {
+		state = "paused"
+	}
+	fmt.Printf("run %s, stage %s %s\n", resp.RunID, resp.Stage, state)
+	for _, q := range []struct {
+		name string
+		cmds []ctlCmd
+	}{{"running", resp.Running}, {"waiting", resp.Waiting}} {
+		fmt.Printf("%s: %d\n", q.name, len(q.cmds))
+		for _, i := range q.cmds {
+			fmt.Printf("  %5d %4d  %-30s %s\n", i.QID, i.Priority, i.Name, i.CmdLine)
+		}
+	}
+	if resp.Error != "" {
+		return fail(resp.Error)
+	}
+	return exitOK
+}
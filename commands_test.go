@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"webrecon/core"
+)
+
+func TestWriteJournal(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []core.JournalEntry{
+		{RunID: "r1", Stage: "s1", Name: "a", CmdLine: "echo 'a'", Attempt: 1, Started: start, Duration: 1.5, ExitCode: 1,
+			Status: core.StatusError, CallBack: "done", CallBackResult: "retried", Operator: "op", Host: "h"},
+		{RunID: "r1", Stage: "s1", Name: "b", CmdLine: "true", Attempt: 1, Started: start, Status: core.StatusSuccess,
+			CallBack: "done", CallBackResult: "ok", Cached: true, Operator: "op", Host: "h"},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"txt", `2024-01-02T03:04:05Z  r1  s1/a                           #1 error              exit 1        1.5s  op@h
+    echo 'a'
+2024-01-02T03:04:05Z  r1  s1/b                           #1 success (cached)   exit 0        0.0s  op@h
+    true
+`},
+		{"csv", `run_id,stage,name,attempt,started,finished,duration_seconds,exit_code,status,cached,cmdline,output_file,callback,callback_result,operator,host
+r1,s1,a,1,2024-01-02T03:04:05Z,0001-01-01T00:00:00Z,1.500,1,error,false,echo 'a',,done,retried,op,h
+r1,s1,b,1,2024-01-02T03:04:05Z,0001-01-01T00:00:00Z,0.000,0,success,true,true,,done,ok,op,h
+`},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := writeJournal(&out, tt.format, entries); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s:\ngot\n%s\nwant\n%s", tt.format, out.String(), tt.want)
+		}
+	}
+	var out bytes.Buffer
+	if err := writeJournal(&out, "jsonl", entries); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], `"cached"`) || !strings.Contains(lines[1], `"cached":true`) {
+		t.Errorf("jsonl:\n%s", out.String())
+	}
+	if err := writeJournal(&out, "xml", entries); err == nil || err.Error() != `unknown journal format "xml"` {
+		t.Errorf("xml format error %v", err)
+	}
+}
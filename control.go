@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"webrecon/core"
+)
+
+// ctlRequest is an action sent to the control socket of a running project, see "webrecon ctl"
+type ctlRequest struct {
+	Action   string `json:"action"` // status, pause, resume, cancel, priority or add
+	QID      int    `json:"qid,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Cmd      string `json:"cmd,omitempty"` // Cmd is the yaml of a command to add to the current stage
+}
+
+type ctlCmd struct {
+	QID      int    `json:"qid"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	CmdLine  string `json:"cmdline"`
+}
+
+// ctlResponse is the result of a ctlRequest, with the state of the current stage after the action
+type ctlResponse struct {
+	Error   string   `json:"error,omitempty"`
+	RunID   string   `json:"run_id"`
+	Stage   string   `json:"stage,omitempty"`
+	Paused  bool     `json:"paused"`
+	Running []ctlCmd `json:"running,omitempty"`
+	Waiting []ctlCmd `json:"waiting,omitempty"`
+}
+
+func (p *Project) controlPath() string {
+	return p.DataDir + "control.sock"
+}
+
+// serveControl listens on the control socket in DataDir until the listener is closed.
+func (p *Project) serveControl() (net.Listener, error) {
+	os.Remove(p.controlPath()) // left over from a run that was killed
+	ln, err := net.Listen("unix", p.controlPath())
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.handleControl(conn)
+		}
+	}()
+	return ln, nil
+}
+
+func (p *Project) handleControl(conn net.Conn) {
+	defer conn.Close()
+	var req ctlRequest
+	err := json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		json.NewEncoder(conn).Encode(ctlResponse{Error: "bad request: " + err.Error()})
+		return
+	}
+	core.Dprint("control:", req.Action)
+	json.NewEncoder(conn).Encode(p.control(req))
+}
+
+// control applies a ctlRequest to the runner of the current stage. pause and resume also apply to the stages after it.
+func (p *Project) control(req ctlRequest) ctlResponse {
+	p.mu.Lock()
+	r := p.runner
+	runID := p.state.RunID
+	if req.Action == "pause" || req.Action == "resume" {
+		p.paused = req.Action == "pause"
+	}
+	p.mu.Unlock()
+	if r == nil {
+		return ctlResponse{Error: "no stage is running"}
+	}
+
+	var err error
+	switch req.Action {
+	case "status":
+	case "pause":
+		r.Pause()
+	case "resume":
+		r.Resume()
+	case "cancel":
+		err = r.Cancel(req.QID)
+	case "priority":
+		err = r.SetPriority(req.QID, req.Priority)
+	case "add":
+		var cmd core.Cmd
+		cmd, err = core.DecodeCmd([]byte(req.Cmd))
+		if err == nil {
+			cmd, err = c.UseTool(cmd)
+		}
+		if err == nil {
+			err = r.Add(cmd)
+		}
+	default:
+		err = errors.New("unknown action " + req.Action)
+	}
+
+	resp := ctlResponse{RunID: runID, Stage: r.Name, Paused: r.Paused()}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	running, waiting := r.GetStatus()
+	resp.Running = ctlCmds(running)
+	resp.Waiting = ctlCmds(waiting)
+	return resp
+}
+
+func ctlCmds(q core.Queue) []ctlCmd {
+	var ret []ctlCmd
+	for qid, i := range q {
+		ret = append(ret, ctlCmd{QID: qid, Name: i.Name, Priority: i.Priority, CmdLine: i.CmdLine})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].QID < ret[j].QID })
+	return ret
+}
+
+// sendControl sends a ctlRequest to the control socket of a running project
+func (p *Project) sendControl(req ctlRequest) (ctlResponse, error) {
+	var resp ctlResponse
+	conn, err := net.Dial("unix", p.controlPath())
+	if err != nil {
+		return resp, errors.New("project " + p.Name + " is not running: " + err.Error())
+	}
+	defer conn.Close()
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return resp, err
+	}
+	err = json.NewDecoder(conn).Decode(&resp)
+	return resp, err
+}
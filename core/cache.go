@@ -0,0 +1,157 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache keeps the results of Cmds with a cache_ttl, so an identical Cmd run again within the ttl reuses
+// the output file and output of the last run instead of executing, its callback is still called.
+type Cache struct {
+	Dir    string
+	Bypass bool // Bypass ignores cached results, new results are still stored
+}
+
+// cacheEntry is the stored result of a Cmd
+type cacheEntry struct {
+	Name       string    `json:"name"`
+	CmdLine    string    `json:"cmdline"`
+	Output     string    `json:"output"`
+	OutputFile string    `json:"output_file"`
+	ExitCode   int       `json:"exit_code"`
+	Finished   time.Time `json:"finished"`
+}
+
+// NewCache returns a Cache storing its entries in dir
+func NewCache(dir string) (*Cache, error) {
+	err := MakeDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+func (ch *Cache) path(key string) string {
+	return ch.Dir + "/" + key + ".json"
+}
+
+// get returns the result stored for key if it is younger than ttl and its output file still exists
+func (ch *Cache) get(key string, ttl time.Duration) (cacheEntry, bool) {
+	var e cacheEntry
+	if ch.Bypass {
+		return e, false
+	}
+	b, err := os.ReadFile(ch.path(key))
+	if err != nil {
+		return e, false
+	}
+	if json.Unmarshal(b, &e) != nil || time.Since(e.Finished) > ttl {
+		return e, false
+	}
+	if e.OutputFile != "" {
+		if _, err := os.Stat(e.OutputFile); err != nil {
+			return e, false
+		}
+	}
+	return e, true
+}
+
+// put stores the result of a Cmd that succeeded under key
+func (ch *Cache) put(key string, cmd Cmd) error {
+	b, err := json.Marshal(cacheEntry{
+		Name:       cmd.Name,
+		CmdLine:    cmd.CmdLine,
+		Output:     cmd.Output,
+		OutputFile: cmd.OutputFile,
+		ExitCode:   cmd.ExitCode,
+		Finished:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	tmp := ch.path(key) + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, ch.path(key))
+}
+
+// cacheKey identifies the inputs of a rendered Cmd: its cmdline or args, env and workdir, with the output file and the
+// files of the vars it used replaced by placeholders, since generated files get a new name every run, plus a hash of the
+// content of those files. the values of env, file, tool, params and the foreach item are part of the rendered cmdline.
+func cacheKey(cmd Cmd) string {
+	var names []string
+	for k := range cmd.vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var paths []string
+	placeholders := make(map[string]string)
+	var inputs [][2]string
+	for _, k := range names {
+		v := cmd.vars[k]
+		if v == "" {
+			continue
+		}
+		if v == cmd.OutputFile {
+			paths = append(paths, v)
+			placeholders[v] = "<output file>"
+			continue
+		}
+		if h, ok := hashFile(v); ok {
+			paths = append(paths, v)
+			placeholders[v] = "<" + k + ">"
+			inputs = append(inputs, [2]string{k, "sha256:" + h})
+		}
+	}
+	// longest first, so a path is not replaced by a var that is a prefix of it
+	sort.SliceStable(paths, func(i, j int) bool { return len(paths[i]) > len(paths[j]) })
+	var pairs []string
+	for _, p := range paths {
+		pairs = append(pairs, p, placeholders[p])
+	}
+	r := strings.NewReplacer(pairs...)
+	args := make([]string, len(cmd.Args))
+	for n, a := range cmd.Args {
+		args[n] = r.Replace(a)
+	}
+	env := make(map[string]string, len(cmd.Env))
+	for k, v := range cmd.Env {
+		env[k] = r.Replace(v)
+	}
+	b, _ := json.Marshal(struct {
+		Name    string
+		CmdLine string
+		Args    []string
+		Env     map[string]string
+		WorkDir string
+		Inputs  [][2]string
+	}{cmd.Name, r.Replace(cmd.CmdLine), args, env, r.Replace(cmd.WorkDir), inputs})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashFile returns the sha256 of a regular file, ok is false if path is not one
+func hashFile(path string) (string, bool) {
+	st, err := os.Stat(path)
+	if err != nil || !st.Mode().IsRegular() {
+		return "", false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", false
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	targets := "a.com"
+	n := 0
+	// like the target and output file vars of the project, every call makes a new file
+	newRunner := func() *CmdRunner {
+		c := newTemplateRunner()
+		c.VarMap["Targets"] = func(*Cmd) string {
+			n++
+			path := filepath.Join(dir, fmt.Sprint("targets-", n))
+			os.WriteFile(path, []byte(targets), 0644)
+			return path
+		}
+		c.VarMap["OutFile"] = func(cmd *Cmd) string {
+			n++
+			cmd.OutputFile = filepath.Join(dir, fmt.Sprint("out-", n))
+			return cmd.OutputFile
+		}
+		return c
+	}
+	key := func(c *CmdRunner, cmd Cmd) string {
+		cmd.Name = "t"
+		if err := c.renderCmd(&cmd); err != nil {
+			t.Fatal(err)
+		}
+		return cacheKey(cmd)
+	}
+	t.Setenv("WR_TEST_PORTS", "80")
+	line := `scan -p {{ env "WR_TEST_PORTS" }} -l {{ .Targets }} -o {{ .OutFile }}`
+	base := key(newRunner(), Cmd{CmdLine: line})
+
+	if k := key(newRunner(), Cmd{CmdLine: line}); k != base {
+		t.Error("new file names changed the key")
+	}
+	if k := key(newRunner(), Cmd{Args: []string{"scan", "-l", "{{ .Targets }}", "-o", "{{ .OutFile }}"}}); k == base {
+		t.Error("args have the key of the cmdline")
+	}
+	c := newRunner()
+	c.Tools["nmap"] = "/opt/nmap"
+	if k := key(c, Cmd{CmdLine: line}); k != base {
+		t.Error("an unused tool changed the key")
+	}
+
+	changes := []struct {
+		name   string
+		change func() (*CmdRunner, Cmd)
+	}{
+		{"env", func() (*CmdRunner, Cmd) {
+			t.Setenv("WR_TEST_PORTS", "443")
+			return newRunner(), Cmd{CmdLine: line}
+		}},
+		{"targets", func() (*CmdRunner, Cmd) {
+			targets = "b.com"
+			return newRunner(), Cmd{CmdLine: line}
+		}},
+		{"tool", func() (*CmdRunner, Cmd) {
+			c := newRunner()
+			c.Tools["nmap"] = "/opt/nmap"
+			return c, Cmd{CmdLine: line + ` {{ tool "nmap" }}`}
+		}},
+		{"params", func() (*CmdRunner, Cmd) {
+			return newRunner(), Cmd{CmdLine: line + " {{ .Params.x }}", Params: map[string]interface{}{"x": "1"}}
+		}},
+		{"cmd env", func() (*CmdRunner, Cmd) {
+			return newRunner(), Cmd{CmdLine: line, Env: map[string]string{"X": "1"}}
+		}},
+	}
+	seen := map[string]string{base: "base"}
+	for _, tt := range changes {
+		k := key(tt.change())
+		if prev, ok := seen[k]; ok {
+			t.Errorf("%s has the key of %s", tt.name, prev)
+		}
+		seen[k] = tt.name
+	}
+}
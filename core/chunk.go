@@ -0,0 +1,150 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validateChunks checks the chunk settings of a Cmd
+func (c *CmdRunner) validateChunks(cmd Cmd) error {
+	switch {
+	case cmd.ChunkSize < 0:
+		return errors.New("chunk_size can not be negative")
+	case cmd.ChunkSize == 0 && cmd.ChunkVar != "":
+		return errors.New("chunk_var requires chunk_size")
+	case cmd.ChunkSize == 0:
+		return nil
+	case cmd.Foreach != "":
+		return errors.New("use either foreach or chunk_size, not both")
+	case cmd.ChunkVar == "":
+		return errors.New("chunk_size requires chunk_var, the var of the target file to split")
+	}
+	if _, ok := c.VarMap[cmd.ChunkVar]; !ok {
+		return errors.New("chunk_var " + cmd.ChunkVar + " is not in CmdRunner.VarMap")
+	}
+	return nil
+}
+
+// chunkJobs generates the ChunkVar file of a Cmd once, splits it into files of ChunkSize lines,
+// and returns one job per file named name[n], with ChunkVar set to its file. the callback and parser are left to the parent,
+// it is called once with the merged output, see mergeChunks.
+func (c *CmdRunner) chunkJobs(parent Cmd) ([]Cmd, error) {
+	path := c.VarMap[parent.ChunkVar](&parent)
+	lines, err := ReadLines(path)
+	if err != nil {
+		return nil, err
+	}
+	lines = CleanSlice(lines)
+	var jobs []Cmd
+	for n := 0; n*parent.ChunkSize < len(lines); n++ {
+		end := (n + 1) * parent.ChunkSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		chunk := fmt.Sprintf("%s.chunk%d", path, n+1)
+		os.Remove(chunk) // left over from an interrupted run
+		err := WriteSliceToFile(lines[n*parent.ChunkSize:end], chunk)
+		if err != nil {
+			return nil, err
+		}
+		job := parent
+		job.Name = fmt.Sprintf("%s[%d]", parent.Name, n+1)
+		job.Parent = parent.Name
+		job.CallBack = "none"
+		job.Parse = nil
+		job.DependsOn = nil
+		job.ChunkSize = 0
+		job.ChunkVar = ""
+		job.Overrides = map[string]string{parent.ChunkVar: chunk}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// mergeChunks joins the output files of the chunk jobs that succeeded into one file for the parent,
+// and runs the parent callback once, even if some chunks failed, so their results are not lost.
+func (c *CmdRunner) mergeChunks(f *fanOut) Cmd {
+	parent := f.parent
+	sort.Slice(f.jobs, func(i, j int) bool {
+		return chunkIndex(parent, f.jobs[i]) < chunkIndex(parent, f.jobs[j])
+	})
+	var outputs []string
+	var files []string
+	for _, j := range f.jobs {
+		outputs = append(outputs, j.Output)
+		if j.Status == StatusSuccess && j.OutputFile != "" {
+			files = append(files, j.OutputFile)
+		}
+	}
+	parent.Output = strings.Join(outputs, "")
+	// the merge is journaled as one attempt of the parent, from the start of its first chunk
+	a := Attempt{Attempt: 1, Started: time.Now()}
+	for _, j := range f.jobs {
+		if len(j.Attempts) > 0 && j.Attempts[0].Started.Before(a.Started) {
+			a.Started = j.Attempts[0].Started
+		}
+	}
+	cbResult := "none"
+	parent.Status = StatusSuccess
+	if len(files) > 0 {
+		parent.OutputFile = filepath.Join(filepath.Dir(files[0]), parent.Name+"-merged")
+		err := mergeFiles(parent.OutputFile, files)
+		if err != nil {
+			parent.Status = StatusError
+			parent.Output += "\nfailed to merge chunk outputs: " + err.Error()
+		}
+	}
+	if parent.Status == StatusSuccess {
+		if len(f.failed) > 0 {
+			parent.Status = StatusError
+			parent.Output += fmt.Sprintf("\n%d of %d chunk(s) failed: %s", len(f.failed), len(f.jobs), strings.Join(f.failed, " "))
+		}
+		var err error
+		cbResult, err = c.callBack(parent)
+		if err != nil {
+			parent.Status = StatusError
+			parent.Output += "\ncallback failed: " + err.Error()
+		}
+	}
+	a.Finished = time.Now()
+	a.Status = parent.Status
+	a.Output = parent.Output
+	parent.Attempts = append(parent.Attempts, a)
+	c.journal(parent, a, cbResult)
+	return parent
+}
+
+// chunkIndex returns n of a chunk job named name[n], so the outputs are merged in the order of the targets
+func chunkIndex(parent, job Cmd) int {
+	n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(job.Name, parent.Name+"["), "]"))
+	return n
+}
+
+// mergeFiles concatenates files into path, replacing it
+func mergeFiles(path string, files []string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			b = append(b, '\n')
+		}
+		_, err = out.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkMerge(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestRunner()
+	c.Name = "s1"
+	c.Journal = j
+	c.MaxThreads = 2
+	c.VarMap["Targets"] = func(*Cmd) string {
+		path := filepath.Join(dir, "targets")
+		WriteSliceToFile([]string{"a", "b", "c", "d", "e"}, path)
+		return path
+	}
+	c.VarMap["OutFile"] = func(cmd *Cmd) string {
+		cmd.OutputFile = filepath.Join(dir, cmd.Name)
+		return cmd.OutputFile
+	}
+	var merged []string
+	c.CallBacks["merged"] = func(cmd Cmd) error {
+		lines, err := ReadLines(cmd.OutputFile)
+		merged = lines
+		return err
+	}
+	r := Runners{{Name: "scan", CmdLine: "tr a-z A-Z < {{ .Targets }} > {{ .OutFile }}", CallBack: "merged", ChunkSize: 2, ChunkVar: "Targets"}}
+	if err := c.Run(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(merged, " ") != "A B C D E" {
+		t.Errorf("merged output %v, want the targets in order", merged)
+	}
+	entries, err := ReadJournal(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(map[string]string)
+	for _, e := range entries {
+		results[e.Name] = e.Status + " " + e.CallBackResult
+	}
+	want := map[string]string{"scan[1]": "success none", "scan[2]": "success none", "scan[3]": "success none", "scan": "success ok"}
+	if len(results) != len(want) {
+		t.Errorf("journal %v, want %v", results, want)
+	}
+	for name, w := range want {
+		if results[name] != w {
+			t.Errorf("journal of %s is %q, want %q", name, results[name], w)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scan-merged")); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,8 +1,10 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 
 	"gopkg.in/yaml.v2"
 )
@@ -10,17 +12,134 @@ import (
 var Cfg Config // gllobal config access for core
 
 type Config struct {
+	Include []string `yaml:"include"` // Include lists config files whose tools, registry and pools are merged into this one
 	General struct {
 		Debug   bool   `yaml:"debug"`
 		Errors  bool   `yaml:"errors"`
 		DataDir string `yaml:"data_dir"`
 	} `yaml:"general"`
 	Recon struct {
-		TargetID Runners `yaml:"target_identification"`
-		Flyover  Runners `yaml:"flyover"`
+		Stages   Stages                  `yaml:"stages"`
+		Pools    map[string]int          `yaml:"pools"`                 // Pools limits how many Cmds of a named pool run at once, eg: network-heavy: 1
+		Vars     map[string]Var          `yaml:"vars"`                  // Vars are variables defined in the config, on top of the VarFuncs of the project, see Var
+		Tools    map[string]ToolTemplate `yaml:"tools"`                 // Tools is the library of command templates stage entries use, see ToolTemplate
+		Registry []Tool                  `yaml:"registry"`              // Registry lists the tools checked before a run, cmdlines use them as {{ tool "name" }}
+		TargetID Runners                 `yaml:"target_identification"` // TargetID is the legacy first stage, used when Stages is empty
+		Flyover  Runners                 `yaml:"flyover"`               // Flyover is the legacy second stage, used when Stages is empty
 	} `yaml:"recon"`
 }
 
+// execution modes for a Stage
+const (
+	ModeParallel   = "parallel"
+	ModeSequential = "sequential"
+)
+
+// Stage is a named step of the recon pipeline, stages are executed in the order they are configured.
+type Stage struct {
+	Name        string   `yaml:"name"`
+	Mode        string   `yaml:"mode"`        // Mode is parallel (default) or sequential
+	Concurrency int      `yaml:"concurrency"` // Concurrency overrides the project MaxThreads for parallel stages
+	Vars        []string `yaml:"vars"`        // Vars limits the VarFuncs available to the stage, all are available when empty
+	CallBacks   []string `yaml:"callbacks"`   // CallBacks limits the callbacks available to the stage, all are available when empty
+	Runners     Runners  `yaml:"runners"`
+}
+type Stages []Stage
+
+// GetStages returns the configured stages, or the legacy target_identification and flyover stages.
+func (c *Config) GetStages() Stages {
+	if len(c.Recon.Stages) > 0 {
+		return c.Recon.Stages
+	}
+	var s Stages
+	if len(c.Recon.TargetID) > 0 {
+		s = append(s, Stage{
+			Name:      "target_identification",
+			Mode:      ModeParallel,
+			Vars:      c.withConfigVars("OutFile", "RootDomsCSV", "RootDomsFile", "IPFile"),
+			CallBacks: []string{"domains"},
+			Runners:   c.Recon.TargetID,
+		})
+	}
+	if len(c.Recon.Flyover) > 0 {
+		s = append(s, Stage{
+			Name:      "flyover",
+			Mode:      ModeSequential,
+			Vars:      c.withConfigVars("OutDir", "IPFile", "DomsFile", "DomsIPFile"),
+			CallBacks: []string{"aq"},
+			Runners:   c.Recon.Flyover,
+		})
+	}
+	return s
+}
+
+// withConfigVars returns the names followed by the recon.vars names, so the legacy stages can use the config vars
+func (c *Config) withConfigVars(names ...string) []string {
+	var extra []string
+	for name := range c.Recon.Vars {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	return append(names, extra...)
+}
+
+// validateConfig checks the recon stages are well formed
+func (c *Config) validateConfig() error {
+	if len(c.Recon.Stages) > 0 && (len(c.Recon.TargetID) > 0 || len(c.Recon.Flyover) > 0) {
+		return errors.New("recon.stages can not be combined with recon.target_identification or recon.flyover")
+	}
+	if err := validateVars(c.Recon.Vars); err != nil {
+		return err
+	}
+	if err := validateRegistry(c.Recon.Registry); err != nil {
+		return err
+	}
+	for name, max := range c.Recon.Pools {
+		if max < 1 {
+			return errors.New("pool " + name + " must allow at least 1 command")
+		}
+	}
+	seen := make(map[string]bool)
+	cmds := make(map[string]bool) // stage/name of every Cmd in the current and earlier stages
+	for n, st := range c.GetStages() {
+		if st.Name == "" {
+			return fmt.Errorf("stage %d requires a name", n+1)
+		}
+		if seen[st.Name] {
+			return errors.New("duplicate stage name " + st.Name)
+		}
+		seen[st.Name] = true
+		if st.Mode != "" && st.Mode != ModeParallel && st.Mode != ModeSequential {
+			return errors.New("stage " + st.Name + ": unknown mode " + st.Mode + ", use parallel or sequential")
+		}
+		if st.Concurrency < 0 {
+			return errors.New("stage " + st.Name + ": concurrency can not be negative")
+		}
+		names := make(map[string]bool)
+		for _, r := range st.Runners {
+			if r.Name == "" {
+				return errors.New("stage " + st.Name + ": every command requires a name")
+			}
+			if names[r.Name] {
+				return errors.New("stage " + st.Name + ": duplicate command name " + r.Name)
+			}
+			names[r.Name] = true
+			cmds[DepKey(st.Name, r.Name)] = true
+			if _, ok := c.Recon.Pools[r.Pool]; r.Pool != "" && !ok {
+				return errors.New("stage " + st.Name + ": " + r.Name + " uses unknown pool " + r.Pool)
+			}
+		}
+		for _, r := range st.Runners {
+			for _, dep := range r.DependsOn {
+				if !cmds[DepKey(st.Name, dep)] {
+					return errors.New("stage " + st.Name + ": " + r.Name + " depends on " + dep + " which is not in this or an earlier stage")
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // InitConfig returns a new decoded Config struct
 func (c *Config) Init(configPath string) error {
 	err := validateConfigPath(configPath)
@@ -39,6 +158,18 @@ func (c *Config) Init(configPath string) error {
 	if err := d.Decode(&c); err != nil {
 		return err
 	}
+	if err := c.include(configPath); err != nil {
+		return err
+	}
+	if err := validateTools(c.Recon.Tools); err != nil {
+		return err
+	}
+	if err := c.useTools(); err != nil {
+		return err
+	}
+	if err := c.validateConfig(); err != nil {
+		return err
+	}
 	Cfg = *c
 	return nil
 }
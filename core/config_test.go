@@ -0,0 +1,38 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestLegacyStageVars(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(`
+recon:
+  vars:
+    Token: {env: WR_TOKEN}
+    Wordlist: {value: /usr/share/words}
+  target_identification:
+    - {name: a, cmdline: "echo {{ .Token }} > {{ .OutFile }}", callback: domains}
+  flyover:
+    - {name: b, cmdline: "echo {{ .Wordlist }}", callback: aq}
+`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"target_identification": "OutFile RootDomsCSV RootDomsFile IPFile Token Wordlist",
+		"flyover":               "OutDir IPFile DomsFile DomsIPFile Token Wordlist",
+	}
+	s := c.GetStages()
+	if len(s) != 2 {
+		t.Fatalf("%d stages, want 2", len(s))
+	}
+	for _, st := range s {
+		if got := strings.Join(st.Vars, " "); got != want[st.Name] {
+			t.Errorf("%s vars %s, want %s", st.Name, got, want[st.Name])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pause stops the runner from starting queued Cmds, running Cmds are left to finish.
+func (c *CmdRunner) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+}
+
+// Resume starts queued Cmds again after Pause.
+func (c *CmdRunner) Resume() {
+	c.mu.Lock()
+	c.paused = false
+	c.mu.Unlock()
+	c.doNextRunner()
+}
+
+// Paused reports if the runner is paused
+func (c *CmdRunner) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Cancel cancels the queued or running Cmd with the QID, a running Cmd is killed like on a timeout.
+func (c *CmdRunner) Cancel(qid int) error {
+	c.mu.Lock()
+	if cmd, ok := c.WaitingQ[qid]; ok {
+		delete(c.WaitingQ, qid)
+		c.mu.Unlock()
+		cmd.Status = StatusCancelled
+		cmd.Output = "cancelled before the command started"
+		c.addCompleted(cmd)
+		c.release()
+		c.doNextRunner()
+		return nil
+	}
+	cancel, ok := c.cancels[qid]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no queued or running command with QID %d", qid)
+	}
+	Dprint("cancelling", qid)
+	cancel()
+	return nil
+}
+
+// SetPriority changes the Priority of a queued Cmd, see queueOrderLocked.
+func (c *CmdRunner) SetPriority(qid int, priority int) error {
+	c.mu.Lock()
+	cmd, ok := c.WaitingQ[qid]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("no queued command with QID %d", qid)
+	}
+	cmd.Priority = priority
+	c.WaitingQ[qid] = cmd
+	c.mu.Unlock()
+	c.doNextRunner()
+	return nil
+}
+
+// Add adds a Cmd to a running Run or RunWait, it is scheduled like the Cmds the run started with.
+// its name must be new to the runner, and its dependencies must be Cmds the runner knows.
+func (c *CmdRunner) Add(cmd Cmd) error {
+	if problems := c.checkCmd(cmd); len(problems) > 0 {
+		return errors.New(cmd.Name + ": " + strings.Join(problems, ", "))
+	}
+	c.mu.Lock()
+	if c.idle == nil {
+		c.mu.Unlock()
+		return errors.New("the runner is not running")
+	}
+	known := c.knownLocked()
+	if cmd.Name == "" || known[DepKey(c.Name, cmd.Name)] {
+		c.mu.Unlock()
+		return errors.New("a new command requires a name that is not used yet")
+	}
+	for _, dep := range cmd.DependsOn {
+		if !known[DepKey(c.Name, dep)] {
+			c.mu.Unlock()
+			return errors.New(cmd.Name + " depends on unknown command " + dep)
+		}
+	}
+	c.blocked = append(c.blocked, cmd)
+	c.pending++
+	c.mu.Unlock()
+	Dprint("added", cmd.Name)
+	c.release()
+	return nil
+}
+
+// knownLocked returns the stage/name keys of every Cmd the runner knows of. c.mu must be held.
+func (c *CmdRunner) knownLocked() map[string]bool {
+	known := make(map[string]bool)
+	for k := range c.Prior {
+		known[k] = true
+	}
+	for k := range c.status {
+		known[k] = true
+	}
+	for _, q := range []Queue{c.RunningQ, c.WaitingQ} {
+		for _, i := range q {
+			known[DepKey(c.Name, i.Name)] = true
+		}
+	}
+	for _, i := range c.blocked {
+		known[DepKey(c.Name, i.Name)] = true
+	}
+	for name := range c.fanOuts {
+		known[DepKey(c.Name, name)] = true
+	}
+	return known
+}
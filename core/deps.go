@@ -0,0 +1,180 @@
+package core
+
+import (
+	"errors"
+	"strings"
+)
+
+// dropPrior forgets the prior Status of Cmds that are about to run again, so their dependents wait for the new result.
+func (c *CmdRunner) dropPrior(r Runners) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, i := range r {
+		delete(c.Prior, DepKey(c.Name, i.Name))
+	}
+}
+
+// validateDeps makes sure every dependency refers to a Cmd of this runner or a Cmd that already completed,
+// and that the dependencies within the runner do not form a cycle.
+func (c *CmdRunner) validateDeps(r Runners) error {
+	keys := make(map[string]bool)
+	for _, i := range r {
+		keys[DepKey(c.Name, i.Name)] = true
+	}
+	for _, i := range r {
+		for _, dep := range i.DependsOn {
+			k := DepKey(c.Name, dep)
+			if k == DepKey(c.Name, i.Name) {
+				return errors.New(i.Name + " depends on itself")
+			}
+			if _, ok := c.Prior[k]; !keys[k] && !ok {
+				return errors.New(i.Name + " depends on unknown command " + dep)
+			}
+		}
+	}
+	_, err := c.sortDeps(r)
+	return err
+}
+
+// sortDeps returns the Cmds ordered so every Cmd comes after its dependencies, keeping the configured order otherwise.
+func (c *CmdRunner) sortDeps(r Runners) (Runners, error) {
+	var sorted Runners
+	done := make(map[string]bool)
+	left := append(Runners{}, r...)
+	inRunner := make(map[string]bool)
+	for _, i := range r {
+		inRunner[DepKey(c.Name, i.Name)] = true
+	}
+	for len(left) > 0 {
+		var next Runners
+		for _, i := range left {
+			ready := true
+			for _, dep := range i.DependsOn {
+				k := DepKey(c.Name, dep)
+				if inRunner[k] && !done[k] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted = append(sorted, i)
+				done[DepKey(c.Name, i.Name)] = true
+			} else {
+				next = append(next, i)
+			}
+		}
+		if len(next) == len(left) {
+			var names []string
+			for _, i := range next {
+				names = append(names, i.Name)
+			}
+			return nil, errors.New("dependency cycle between " + strings.Join(names, ", "))
+		}
+		left = next
+	}
+	return sorted, nil
+}
+
+// depsDone reports if every dependency of cmd succeeded, or the first dependency that did not.
+func (c *CmdRunner) depsDone(cmd Cmd) (bool, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.depsDoneLocked(cmd)
+}
+
+func (c *CmdRunner) depsDoneLocked(cmd Cmd) (bool, string) {
+	ready := true
+	for _, dep := range cmd.DependsOn {
+		k := DepKey(c.Name, dep)
+		st, ok := c.status[k]
+		if !ok {
+			st, ok = c.Prior[k]
+		}
+		if !ok {
+			ready = false
+			continue
+		}
+		if st != StatusSuccess {
+			return false, dep
+		}
+	}
+	return ready, ""
+}
+
+// skip marks a Cmd as skipped because dep did not succeed
+func (c *CmdRunner) skip(cmd Cmd, dep string) {
+	Dprint("skipping", cmd.Name, "dependency", dep, "did not succeed")
+	cmd.Status = StatusSkipped
+	cmd.Output = "dependency " + dep + " did not succeed"
+	c.addCompleted(cmd)
+}
+
+// release starts the blocked Cmds whose dependencies succeeded, and skips the ones with a dependency that did not.
+// foreach and chunked Cmds are expanded into their jobs once they are ready.
+func (c *CmdRunner) release() {
+	for {
+		c.mu.Lock()
+		if c.ctx.Err() != nil {
+			cancelled := c.blocked
+			c.blocked = nil
+			c.mu.Unlock()
+			for _, i := range cancelled {
+				c.cancel(i)
+			}
+			return
+		}
+		var ready, still, skipped Runners
+		var failedDeps []string
+		for n, i := range c.blocked {
+			ok, failed := c.depsDoneLocked(i)
+			switch {
+			case failed != "":
+				skipped = append(skipped, i)
+				failedDeps = append(failedDeps, failed)
+			case c.sequential:
+				// only the first Cmd can start, once nothing else is queued or running
+				if ok && len(c.RunningQ)+len(c.WaitingQ)+len(c.fanOuts) == 0 {
+					ready = append(ready, i)
+					still = append(still, c.blocked[n+1:]...)
+				} else {
+					still = append(still, c.blocked[n:]...)
+				}
+			case ok:
+				ready = append(ready, i)
+			default:
+				still = append(still, i)
+			}
+			if c.sequential && failed == "" {
+				break
+			}
+		}
+		c.blocked = still
+		c.mu.Unlock()
+
+		// queue everything that is ready before starting any, so the highest Priority goes first
+		done := len(skipped) > 0
+		for _, i := range ready {
+			if !i.fansOut() {
+				c.enqueue(i)
+				continue
+			}
+			jobs := c.expand(i)
+			if jobs == nil {
+				done = true
+			}
+			for _, job := range jobs {
+				c.enqueue(job)
+			}
+		}
+		if len(ready) > 0 {
+			c.doNextRunner()
+		}
+		for n, i := range skipped {
+			c.skip(i, failedDeps[n])
+		}
+		// skipping, or a Cmd that fans out without jobs, may complete the dependencies of other blocked Cmds
+		if !done {
+			return
+		}
+	}
+}
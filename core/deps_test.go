@@ -0,0 +1,152 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateDeps(t *testing.T) {
+	tests := []struct {
+		name  string
+		prior StatusMap
+		r     Runners
+		err   string
+	}{
+		{"no deps", nil, Runners{{Name: "a"}, {Name: "b"}}, ""},
+		{"same stage", nil, Runners{{Name: "a"}, {Name: "b", DependsOn: []string{"a"}}}, ""},
+		{"same stage by key", nil, Runners{{Name: "a"}, {Name: "b", DependsOn: []string{"s2/a"}}}, ""},
+		{"later in the list", nil, Runners{{Name: "b", DependsOn: []string{"a"}}, {Name: "a"}}, ""},
+		{"prior stage", StatusMap{"s1/x": StatusSuccess}, Runners{{Name: "a", DependsOn: []string{"s1/x"}}}, ""},
+		{"prior failed", StatusMap{"s1/x": StatusError}, Runners{{Name: "a", DependsOn: []string{"s1/x"}}}, ""},
+		{"unknown", nil, Runners{{Name: "a", DependsOn: []string{"b"}}}, "a depends on unknown command b"},
+		{"unknown stage", StatusMap{"s1/x": StatusSuccess}, Runners{{Name: "a", DependsOn: []string{"s3/x"}}}, "a depends on unknown command s3/x"},
+		{"prior name without stage", StatusMap{"s1/x": StatusSuccess}, Runners{{Name: "a", DependsOn: []string{"x"}}}, "a depends on unknown command x"},
+		{"itself", nil, Runners{{Name: "a", DependsOn: []string{"a"}}}, "a depends on itself"},
+		{"cycle", nil, Runners{{Name: "a", DependsOn: []string{"c"}}, {Name: "b", DependsOn: []string{"a"}}, {Name: "c", DependsOn: []string{"b"}}, {Name: "d"}}, "dependency cycle between a, b, c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCmdRunner()
+			c.Name = "s2"
+			for k, v := range tt.prior {
+				c.Prior[k] = v
+			}
+			err := c.validateDeps(tt.r)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Errorf("error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSortDeps(t *testing.T) {
+	tests := []struct {
+		name string
+		r    Runners
+		want string
+	}{
+		{"config order", Runners{{Name: "a"}, {Name: "b"}, {Name: "c"}}, "a b c"},
+		{"dep first", Runners{{Name: "b", DependsOn: []string{"a"}}, {Name: "a"}, {Name: "c"}}, "a c b"},
+		{"chain", Runners{{Name: "c", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}, {Name: "a"}}, "a b c"},
+		{"diamond", Runners{{Name: "d", DependsOn: []string{"b", "c"}}, {Name: "c", DependsOn: []string{"a"}}, {Name: "b", DependsOn: []string{"a"}}, {Name: "a"}}, "a c b d"},
+		{"prior stage", Runners{{Name: "b", DependsOn: []string{"s1/x"}}, {Name: "a"}}, "b a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCmdRunner()
+			c.Name = "s2"
+			sorted, err := c.sortDeps(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, i := range sorted {
+				names = append(names, i.Name)
+			}
+			if got := strings.Join(names, " "); got != tt.want {
+				t.Errorf("order %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	tests := []struct {
+		name  string
+		prior StatusMap
+		r     Runners
+		want  map[string]string
+	}{
+		{
+			"success",
+			nil,
+			Runners{{Name: "a", CmdLine: "true"}, {Name: "b", CmdLine: "true", DependsOn: []string{"a"}}},
+			map[string]string{"a": StatusSuccess, "b": StatusSuccess},
+		},
+		{
+			"skip propagates",
+			nil,
+			Runners{
+				{Name: "a", CmdLine: "false"},
+				{Name: "b", CmdLine: "true", DependsOn: []string{"a"}},
+				{Name: "c", CmdLine: "true", DependsOn: []string{"b"}},
+				{Name: "d", CmdLine: "true"},
+				{Name: "e", CmdLine: "true", DependsOn: []string{"d", "c"}},
+			},
+			map[string]string{"a": StatusError, "b": StatusSkipped, "c": StatusSkipped, "d": StatusSuccess, "e": StatusSkipped},
+		},
+		{
+			"prior stage succeeded",
+			StatusMap{"s1/x": StatusSuccess},
+			Runners{{Name: "a", CmdLine: "true", DependsOn: []string{"s1/x"}}},
+			map[string]string{"a": StatusSuccess},
+		},
+		{
+			"prior stage failed",
+			StatusMap{"s1/x": StatusTimeout},
+			Runners{{Name: "a", CmdLine: "true", DependsOn: []string{"s1/x"}}, {Name: "b", CmdLine: "true", DependsOn: []string{"a"}}},
+			map[string]string{"a": StatusSkipped, "b": StatusSkipped},
+		},
+		{
+			"rerun replaces prior",
+			StatusMap{"s2/a": StatusError},
+			Runners{{Name: "a", CmdLine: "true"}, {Name: "b", CmdLine: "true", DependsOn: []string{"a"}}},
+			map[string]string{"a": StatusSuccess, "b": StatusSuccess},
+		},
+	}
+	for _, tt := range tests {
+		for _, wait := range []bool{false, true} {
+			c := newTestRunner()
+			c.Name = "s2"
+			c.MaxThreads = 4
+			for k, v := range tt.prior {
+				c.Prior[k] = v
+			}
+			r := append(Runners{}, tt.r...)
+			for n := range r {
+				r[n].CallBack = "done"
+			}
+			var err error
+			if wait {
+				err = c.RunWait(context.Background(), r)
+			} else {
+				err = c.Run(context.Background(), r)
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if len(c.Completed) != len(r) {
+				t.Errorf("%s: %d Cmds completed, want %d", tt.name, len(c.Completed), len(r))
+			}
+			for _, cmd := range c.Completed {
+				if cmd.Status != tt.want[cmd.Name] {
+					t.Errorf("%s: %s is %s, want %s (RunWait %v)", tt.name, cmd.Name, cmd.Status, tt.want[cmd.Name], wait)
+				}
+			}
+		}
+	}
+}
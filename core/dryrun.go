@@ -0,0 +1,115 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// programName matches a first word of a cmdline that names a program, and not a variable assignment, subshell or redirect.
+var programName = regexp.MustCompile(`^[A-Za-z0-9_./+-]+$`)
+
+// dryRun renders every Cmd in dependency order without running it or its callback.
+// the Cmds are added to Completed with StatusPlanned, or with StatusError and the problems found as Output.
+// problems are missing callbacks and VarMap entries, template errors and programs that are not installed,
+// the problems of all Cmds are returned together. foreach and chunked Cmds are added once, with the number of jobs they have now
+// and the first of them rendered as a sample.
+func (c *CmdRunner) dryRun(r Runners) error {
+	var errs []string
+	if err := c.validateDeps(r); err != nil {
+		errs = append(errs, err.Error())
+	}
+	r, err := c.sortDeps(r)
+	if err != nil {
+		return err
+	}
+	for _, i := range r {
+		if !i.fansOut() || len(c.checkCmd(i)) > 0 {
+			errs = append(errs, c.planCmd(i)...)
+			continue
+		}
+		var jobs []Cmd
+		if i.ChunkSize > 0 {
+			jobs, err = c.chunkJobs(i)
+			if err != nil {
+				errs = append(errs, i.Name+": failed to split "+i.ChunkVar+": "+err.Error())
+			}
+		} else {
+			jobs = c.foreachJobs(i)
+		}
+		if len(jobs) == 0 {
+			i.Output = "no items or targets yet, rendered without them"
+			errs = append(errs, c.planCmd(i)...)
+			continue
+		}
+		// the jobs only differ by their item or chunk, so one is rendered for the report
+		job := jobs[0]
+		problems := c.checkPlan(&job)
+		i.CmdLine = job.CmdLine
+		i.Args = job.Args
+		i.Output = fmt.Sprintf("%d job(s), eg: %s", len(jobs), job.Name)
+		errs = append(errs, c.addPlanned(i, problems)...)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// planCmd renders and checks a Cmd for dryRun, adds it to Completed and returns its problems.
+func (c *CmdRunner) planCmd(i Cmd) []string {
+	problems := c.checkPlan(&i)
+	return c.addPlanned(i, problems)
+}
+
+// checkPlan renders a Cmd in place and returns its problems
+func (c *CmdRunner) checkPlan(i *Cmd) []string {
+	problems := c.checkCmd(*i)
+	if len(problems) == 0 {
+		err := c.parseVars(i)
+		if err != nil {
+			problems = append(problems, "failed to render cmdline: "+err.Error())
+		} else if err := findProgram(*i); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// addPlanned adds a Cmd to Completed as planned, or with its problems as Output, and returns the problems prefixed with its name
+func (c *CmdRunner) addPlanned(i Cmd, problems []string) []string {
+	i.Status = StatusPlanned
+	var errs []string
+	if len(problems) > 0 {
+		i.Status = StatusError
+		i.Output = strings.Join(problems, "\n")
+		for _, p := range problems {
+			errs = append(errs, i.Name+": "+p)
+		}
+	}
+	c.addCompleted(i)
+	return errs
+}
+
+// findProgram checks the program a rendered Cmd starts is installed.
+// for cmdlines that is the first word, looked up by bash so builtins and functions are found too.
+func findProgram(cmd Cmd) error {
+	if len(cmd.Args) > 0 {
+		if _, err := exec.LookPath(cmd.Args[0]); err != nil {
+			return errors.New(cmd.Args[0] + " is not installed or not in PATH")
+		}
+		return nil
+	}
+	words := strings.Fields(cmd.CmdLine)
+	if len(words) == 0 || !programName.MatchString(words[0]) {
+		return nil // too clever for a dry run, bash will tell
+	}
+	// type only looks the name up, nothing is executed
+	err := exec.Command("bash", "-c", `type -t -- "$1" >/dev/null`, "bash", words[0]).Run()
+	if err != nil {
+		return errors.New(words[0] + " is not installed or not in PATH")
+	}
+	return nil
+}
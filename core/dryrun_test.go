@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestRunner()
+	c.DryRun = true
+	c.Items["doms"] = func() []string { return []string{"a.com", "b c", "d.com"} }
+	c.Items["none"] = func() []string { return nil }
+	c.VarMap["Targets"] = func(*Cmd) string {
+		path := filepath.Join(dir, "targets")
+		WriteSliceToFile([]string{"a", "b", "c"}, path)
+		return path
+	}
+	r := Runners{
+		{Name: "plain", CmdLine: "echo x", CallBack: "done"},
+		{Name: "each", CmdLine: "echo {{ .Item }}", Foreach: "doms", CallBack: "done"},
+		{Name: "empty", CmdLine: "echo {{ .Item }}", Foreach: "none", CallBack: "done"},
+		{Name: "chunked", CmdLine: "wc -l {{ .Targets }}", ChunkSize: 2, ChunkVar: "Targets", CallBack: "done"},
+		{Name: "missing", CmdLine: "wr-missing-tool {{ .Item }}", Foreach: "doms", CallBack: "done"},
+	}
+	err := c.Run(context.Background(), r)
+	if err == nil || err.Error() != "missing: wr-missing-tool is not installed or not in PATH" {
+		t.Errorf("error %v", err)
+	}
+	targets := filepath.Join(dir, "targets")
+	want := []string{
+		"plain planned echo x | ",
+		"each planned echo 'a.com' | 3 job(s), eg: each[a.com]",
+		"empty planned echo '' | no items or targets yet, rendered without them",
+		"chunked planned wc -l '" + targets + ".chunk1' | 2 job(s), eg: chunked[1]",
+		"missing error wr-missing-tool 'a.com' | wr-missing-tool is not installed or not in PATH",
+	}
+	var got []string
+	for _, i := range c.Completed {
+		got = append(got, fmt.Sprintf("%s %s %s | %s", i.Name, i.Status, i.CmdLine, i.Output))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("planned\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
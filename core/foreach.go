@@ -0,0 +1,121 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ItemFunc returns the items a foreach Cmd is expanded into, it is called when the Cmd is ready to start.
+type ItemFunc func() []string
+type ItemMap map[string]ItemFunc
+
+// fanOut tracks the jobs of a foreach or chunked Cmd until they all completed
+type fanOut struct {
+	parent  Cmd
+	left    int
+	items   int
+	jobs    []Cmd // completed chunk jobs
+	failed  []string
+	cancels int
+}
+
+// fansOut reports if a Cmd is run as several jobs, see expand
+func (cmd Cmd) fansOut() bool {
+	return cmd.Foreach != "" || cmd.ChunkSize > 0
+}
+
+// expand returns the jobs of a foreach or chunked Cmd, and tracks them so the parent completes once all its jobs did.
+// if there are no jobs to run the parent is completed right away and nil is returned.
+func (c *CmdRunner) expand(parent Cmd) []Cmd {
+	var jobs []Cmd
+	var err error
+	if parent.ChunkSize > 0 {
+		jobs, err = c.chunkJobs(parent)
+	} else {
+		jobs = c.foreachJobs(parent)
+	}
+	if err != nil || len(jobs) == 0 {
+		parent.Status = StatusSuccess
+		parent.Output = "nothing to run"
+		if err != nil {
+			parent.Status = StatusError
+			parent.Output = "failed to split " + parent.ChunkVar + ": " + err.Error()
+		}
+		c.addCompleted(parent)
+		return nil
+	}
+	c.mu.Lock()
+	c.fanOuts[parent.Name] = &fanOut{parent: parent, left: len(jobs), items: len(jobs)}
+	if c.idle != nil {
+		c.pending += len(jobs)
+	}
+	c.mu.Unlock()
+	Dprint("expanded", parent.Name, "into", len(jobs), "jobs")
+	return jobs
+}
+
+// foreachJobs returns one job per item of a foreach Cmd, named name[item], with {{ .Item }} set to the item.
+// items that already succeeded in a prior run are not run again.
+func (c *CmdRunner) foreachJobs(parent Cmd) []Cmd {
+	items := UniqueSlice(c.Items[parent.Foreach]())
+	var jobs []Cmd
+	for _, item := range items {
+		job := parent
+		job.Name = parent.Name + "[" + item + "]"
+		job.Item = item
+		job.Parent = parent.Name
+		job.Foreach = ""
+		job.DependsOn = nil
+		if c.Prior[DepKey(c.Name, job.Name)] == StatusSuccess {
+			Dprint("skipping", job.Name, "already completed")
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// jobDone records a completed job, and completes its parent when it was the last one.
+// the parent succeeds if every job did, and is cancelled if any job was.
+// the output of chunk jobs is merged and passed to the parent callback.
+func (c *CmdRunner) jobDone(job Cmd) {
+	c.mu.Lock()
+	f, ok := c.fanOuts[job.Parent]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	f.left--
+	if f.parent.ChunkSize > 0 {
+		f.jobs = append(f.jobs, job)
+	}
+	switch job.Status {
+	case StatusSuccess:
+	case StatusCancelled:
+		f.cancels++
+	default:
+		f.failed = append(f.failed, job.Name)
+	}
+	if f.left > 0 {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.fanOuts, job.Parent)
+	c.mu.Unlock()
+
+	parent := f.parent
+	parent.Output = fmt.Sprintf("foreach %s: %d item(s)", parent.Foreach, f.items)
+	switch {
+	case f.cancels > 0:
+		parent.Status = StatusCancelled
+		parent.Output = "run cancelled before all jobs completed"
+	case parent.ChunkSize > 0:
+		parent = c.mergeChunks(f)
+	case len(f.failed) > 0:
+		parent.Status = StatusError
+		parent.Output += ", failed: " + strings.Join(f.failed, " ")
+	default:
+		parent.Status = StatusSuccess
+	}
+	c.addCompleted(parent)
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestForeachJobs(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		prior []string // jobs that succeeded in a prior run
+		want  []string // rendered cmdlines
+	}{
+		{"items", []string{"a.com", "b.com"}, nil, []string{"echo 'a.com'", "echo 'b.com'"}},
+		{"duplicates", []string{"a.com", "b.com", "a.com"}, nil, []string{"echo 'a.com'", "echo 'b.com'"}},
+		{"empty items", nil, nil, nil},
+		{"quoting", []string{"a b", "x'; rm -rf /", "$(id)"}, nil, []string{"echo 'a b'", `echo 'x'\''; rm -rf /'`, "echo '$(id)'"}},
+		{"prior successes skipped", []string{"a.com", "b.com"}, []string{"t[a.com]"}, []string{"echo 'b.com'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestRunner()
+			c.Name = "s1"
+			c.Items["doms"] = func() []string { return tt.items }
+			for _, name := range tt.prior {
+				c.Prior[DepKey("s1", name)] = StatusSuccess
+			}
+			parent := Cmd{Name: "t", CmdLine: "echo {{ .Item }}", Foreach: "doms", DependsOn: []string{"x"}, CallBack: "done"}
+			var got []string
+			for _, job := range c.foreachJobs(parent) {
+				if job.Name != "t["+job.Item+"]" || job.Parent != "t" || job.Foreach != "" || job.DependsOn != nil {
+					t.Errorf("job %s of item %q parent %q foreach %q depends on %v", job.Name, job.Item, job.Parent, job.Foreach, job.DependsOn)
+				}
+				if err := c.renderCmd(&job); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, job.CmdLine)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("jobs %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandNoItems(t *testing.T) {
+	c := newTestRunner()
+	c.Items["doms"] = func() []string { return nil }
+	if jobs := c.expand(Cmd{Name: "t", CmdLine: "echo {{ .Item }}", Foreach: "doms"}); jobs != nil {
+		t.Errorf("jobs %v, want none", jobs)
+	}
+	if len(c.Completed) != 1 || c.Completed[0].Status != StatusSuccess || c.Completed[0].Output != "nothing to run" {
+		t.Errorf("completed %+v, want the parent with nothing to run", c.Completed)
+	}
+	if len(c.fanOuts) != 0 {
+		t.Error("a parent without jobs is tracked")
+	}
+}
+
+func TestJobDone(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string // of the jobs, in the order they complete
+		status   string
+		output   string
+	}{
+		{"all succeeded", []string{StatusSuccess, StatusSuccess, StatusSuccess}, StatusSuccess, "foreach doms: 3 item(s)"},
+		{"some failed", []string{StatusSuccess, StatusError, StatusTimeout}, StatusError, "foreach doms: 3 item(s), failed: t[b] t[c]"},
+		{"cancelled", []string{StatusError, StatusCancelled, StatusSuccess}, StatusCancelled, "run cancelled before all jobs completed"},
+		{"one item", []string{StatusSuccess}, StatusSuccess, "foreach doms: 1 item(s)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestRunner()
+			items := []string{"a", "b", "c"}[:len(tt.statuses)]
+			c.Items["doms"] = func() []string { return items }
+			jobs := c.expand(Cmd{Name: "t", CmdLine: "echo {{ .Item }}", Foreach: "doms", CallBack: "done"})
+			if len(jobs) != len(items) {
+				t.Fatalf("%d jobs, want %d", len(jobs), len(items))
+			}
+			for n, job := range jobs {
+				job.Status = tt.statuses[n]
+				c.addCompleted(job) // calls jobDone
+				if done := len(c.Completed) > n+1; done != (n == len(jobs)-1) {
+					t.Fatalf("parent completed after %d of %d jobs", n+1, len(jobs))
+				}
+			}
+			parent := c.Completed[len(c.Completed)-1]
+			if got := fmt.Sprint(parent.Name, " ", parent.Status, " ", parent.Output); got != fmt.Sprint("t ", tt.status, " ", tt.output) {
+				t.Errorf("parent %s, want %s %s", got, tt.status, tt.output)
+			}
+			if len(c.fanOuts) != 0 {
+				t.Error("the parent is still tracked")
+			}
+		})
+	}
+	// a job of a parent that is not tracked is ignored
+	c := newTestRunner()
+	c.jobDone(Cmd{Name: "x[a]", Parent: "x", Status: StatusSuccess})
+	if len(c.Completed) != 0 {
+		t.Errorf("completed %+v", c.Completed)
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JournalEntry is one execution of a Cmd, written as a line of JSON.
+type JournalEntry struct {
+	RunID          string    `json:"run_id"`
+	Stage          string    `json:"stage"`
+	Name           string    `json:"name"`
+	CmdLine        string    `json:"cmdline"`
+	Args           []string  `json:"args,omitempty"`
+	Attempt        int       `json:"attempt"`
+	Started        time.Time `json:"started"`
+	Finished       time.Time `json:"finished"`
+	Duration       float64   `json:"duration_seconds"`
+	ExitCode       int       `json:"exit_code"`
+	Status         string    `json:"status"`
+	OutputFile     string    `json:"output_file,omitempty"`
+	CallBack       string    `json:"callback"`
+	CallBackResult string    `json:"callback_result"` // ok, none, error: ..., or why the callback did not run
+	Cached         bool      `json:"cached,omitempty"`
+	Operator       string    `json:"operator"`
+	Host           string    `json:"host"`
+}
+
+// Journal is an append only JSON lines record of every command executed, it is safe for concurrent use.
+type Journal struct {
+	RunID    string // RunID is added to every entry
+	path     string
+	operator string
+	host     string
+	mu       sync.Mutex
+}
+
+// OpenJournal returns a Journal appending to path, the operator and host are looked up once.
+func OpenJournal(path string) (*Journal, error) {
+	err := MakeDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path}
+	if u, err := user.Current(); err == nil {
+		j.operator = u.Username
+	} else {
+		j.operator = os.Getenv("USER")
+	}
+	j.host, _ = os.Hostname()
+	return j, nil
+}
+
+// Record appends an attempt of a Cmd that ran in stage to the journal.
+func (j *Journal) Record(stage string, cmd Cmd, a Attempt, cbResult string) error {
+	e := JournalEntry{
+		RunID:          j.RunID,
+		Stage:          stage,
+		Name:           cmd.Name,
+		CmdLine:        cmd.CmdLine,
+		Args:           cmd.Args,
+		Attempt:        a.Attempt,
+		Started:        a.Started,
+		Finished:       a.Finished,
+		Duration:       a.Finished.Sub(a.Started).Seconds(),
+		ExitCode:       a.ExitCode,
+		Status:         a.Status,
+		OutputFile:     cmd.OutputFile,
+		CallBack:       cmd.CallBack,
+		CallBackResult: cbResult,
+		Cached:         a.Cached,
+		Operator:       j.operator,
+		Host:           j.host,
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// ReadJournal returns every entry of a journal file, oldest first.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e JournalEntry
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
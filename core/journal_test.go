@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "journal.jsonl")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.RunID = "r1"
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []struct {
+		cmd      Cmd
+		a        Attempt
+		cbResult string
+	}{
+		{Cmd{Name: "a", CmdLine: "echo 'a'", CallBack: "done", OutputFile: "/tmp/a"},
+			Attempt{Attempt: 1, Status: StatusError, ExitCode: 1, Started: start, Finished: start.Add(1500 * time.Millisecond)}, "retried"},
+		{Cmd{Name: "a", CmdLine: "echo 'a'", CallBack: "done", OutputFile: "/tmp/a"},
+			Attempt{Attempt: 2, Status: StatusSuccess, Started: start, Finished: start.Add(time.Second)}, "ok"},
+		{Cmd{Name: "b", Args: []string{"nmap", "-sV"}, CmdLine: "'nmap' '-sV'", CallBack: "done"},
+			Attempt{Attempt: 1, Status: StatusSuccess, Started: start, Finished: start, Cached: true}, "ok"},
+	}
+	for _, r := range records {
+		if err := j.Record("s1", r.cmd, r.a, r.cbResult); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"r1 s1 a echo 'a' [] #1 error exit 1 1.5s /tmp/a done retried cached false",
+		"r1 s1 a echo 'a' [] #2 success exit 0 1s /tmp/a done ok cached false",
+		"r1 s1 b 'nmap' '-sV' [nmap -sV] #1 success exit 0 0s  done ok cached true",
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d entries, want %d", len(entries), len(want))
+	}
+	for n, e := range entries {
+		got := fmt.Sprintf("%s %s %s %s %v #%d %s exit %d %vs %s %s %s cached %v", e.RunID, e.Stage, e.Name, e.CmdLine, e.Args, e.Attempt,
+			e.Status, e.ExitCode, e.Duration, e.OutputFile, e.CallBack, e.CallBackResult, e.Cached)
+		if got != want[n] {
+			t.Errorf("entry %d:\ngot  %s\nwant %s", n, got, want[n])
+		}
+		if !e.Started.Equal(start) || e.Operator == "" {
+			t.Errorf("entry %d started %v by %q", n, e.Started, e.Operator)
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	// cached and args are left out of the records that do not have them
+	if strings.Contains(lines[0], `"cached"`) || strings.Contains(lines[0], `"args"`) ||
+		!strings.Contains(lines[2], `"cached":true`) || !strings.Contains(lines[2], `"args":["nmap","-sV"]`) {
+		t.Errorf("records:\n%s", b)
+	}
+}
+
+func TestJournalConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			cmd := Cmd{Name: fmt.Sprint("c", n), CmdLine: strings.Repeat("x", 4096)}
+			if err := j.Record("s1", cmd, Attempt{Attempt: 1}, "ok"); err != nil {
+				t.Error(err)
+			}
+		}(n)
+	}
+	wg.Wait()
+	entries, err := ReadJournal(path)
+	if err != nil || len(entries) != 20 {
+		t.Errorf("%d entries, error %v, want 20 whole records", len(entries), err)
+	}
+}
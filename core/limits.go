@@ -0,0 +1,80 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var memorySize = regexp.MustCompile(`^(?i)(\d+)\s*([kmgt]?)(i?b)?$`)
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// outOfMemory matches the errors programs print when an allocation fails, used to tell a max_memory violation from other failures
+var outOfMemory = regexp.MustCompile(`(?i)out of memory|cannot allocate memory|bad_alloc|MemoryError`)
+
+// parseMemory converts a size like 512M or 2G to bytes, units are powers of 1024.
+func parseMemory(s string) (uint64, error) {
+	m := memorySize.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, errors.New("invalid memory size " + s + ", use a number of bytes or K, M, G, T")
+	}
+	n, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	shift := strings.Index("kmgt", strings.ToLower(m[2])) + 1
+	if m[2] == "" {
+		shift = 0
+	}
+	return n << (10 * shift), nil
+}
+
+// hasLimits reports if a Cmd sets any of the linux only process limits
+func (cmd Cmd) hasLimits() bool {
+	return cmd.MaxMemory != "" || cmd.MaxCPUTime > 0 || cmd.Nice != 0
+}
+
+// validateLimits checks the env, memory, cpu and nice settings of a Cmd
+func (cmd Cmd) validateLimits() error {
+	for k := range cmd.Env {
+		if !envName.MatchString(k) {
+			return errors.New("invalid env name " + k)
+		}
+	}
+	if cmd.MaxMemory != "" {
+		n, err := parseMemory(cmd.MaxMemory)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errors.New("max_memory must be more than 0")
+		}
+	}
+	if cmd.MaxCPUTime < 0 {
+		return errors.New("max_cpu_time can not be negative")
+	}
+	if cmd.Nice < -20 || cmd.Nice > 19 {
+		return errors.New("nice must be between -20 and 19")
+	}
+	if cmd.hasLimits() && !limitsSupported {
+		return errors.New("max_memory, max_cpu_time and nice are only supported on linux")
+	}
+	return nil
+}
+
+// environ returns the environment of webrecon with the Env of the Cmd added
+func (cmd Cmd) environ() []string {
+	env := os.Environ()
+	var keys []string
+	for k := range cmd.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+cmd.Env[k])
+	}
+	return env
+}
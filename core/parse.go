@@ -0,0 +1,480 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parser formats
+const (
+	ParseLines = "lines" // every line is a record with the field "line", lines are sorted into domains, ips and urls when Fields is empty
+	ParseJSON  = "json"  // one json document, Records is the dotted path of its array of records
+	ParseJSONL = "jsonl" // one json object per line, lines that are not json are ignored
+	ParseRegex = "regex" // every match of Pattern is a record, its named groups are the fields
+	ParseXML   = "xml"   // one xml document, Records is the slash separated path of the record elements from the root
+)
+
+// result fields a Parser can fill
+var resultFields = []string{"domain", "ip", "port", "proto", "url", "finding", "severity"}
+
+// Parser turns the output file of a Cmd into Results without a Go callback, the output is parsed when the
+// Cmd has no output file. Fields maps a result field to the path of its value in each record, eg:
+//
+//	parse: {format: jsonl, fields: {domain: host, ip: a}}
+//	parse: {format: xml, records: nmaprun/host/ports/port, fields: {port: "@portid", proto: "@protocol", ip: "../../address/@addr"}}
+//
+// json paths are dot separated keys and array indexes, arrays yield every element. xml paths are slash separated
+// element names, .. for the parent and @name for an attribute, an element yields its text.
+type Parser struct {
+	Format  string            `yaml:"format"`
+	Records string            `yaml:"records"` // Records is the path of the records in a json or xml document, the whole document when empty
+	Pattern string            `yaml:"pattern"` // Pattern is the regex of the regex format
+	Fields  map[string]string `yaml:"fields"`  // Fields maps domain, ip, port, proto, url, finding and severity to a path, defaults to their own name
+}
+
+// Results are the domains, ips, ports, urls and findings parsed from the output of a Cmd
+type Results struct {
+	Domains  []string
+	IPs      []string
+	Ports    []Port
+	URLs     []string
+	Findings []Finding
+}
+
+// Port is an open port of a host, Host is an ip or a domain
+type Port struct {
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	Proto string `json:"proto"`
+}
+
+// Finding is something a tool reported about a target
+type Finding struct {
+	Cmd      string `json:"cmd"`
+	Target   string `json:"target"`
+	Name     string `json:"name"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// record maps a field path to its values
+type record func(path string) []string
+
+// validateParser checks the format, pattern and fields of the Parser of a Cmd
+func (i Cmd) validateParser() error {
+	p := i.Parse
+	if p == nil {
+		return nil
+	}
+	switch p.Format {
+	case ParseLines, ParseJSON, ParseJSONL:
+	case ParseRegex:
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return errors.New("parse: bad pattern: " + err.Error())
+		}
+		if len(p.Fields) == 0 && len(fieldGroups(re)) == 0 {
+			return errors.New("parse: pattern needs a named group like (?P<domain>...) or fields")
+		}
+	case ParseXML:
+		if p.Records == "" {
+			return errors.New("parse: records is required for the xml format, eg: nmaprun/host")
+		}
+	default:
+		return errors.New("parse: unknown format " + p.Format + ", use lines, json, jsonl, regex or xml")
+	}
+	for f := range p.Fields {
+		if !SliceContains(resultFields, f) {
+			return errors.New("parse: unknown field " + f + ", use " + strings.Join(resultFields, ", "))
+		}
+	}
+	return nil
+}
+
+// fieldGroups returns the named groups of re that are result fields
+func fieldGroups(re *regexp.Regexp) map[string]string {
+	m := make(map[string]string)
+	for _, g := range re.SubexpNames() {
+		if SliceContains(resultFields, g) {
+			m[g] = g
+		}
+	}
+	return m
+}
+
+// parseOutput parses the output file of a Cmd, or its output when it has none
+func (p *Parser) parseOutput(cmd Cmd) (Results, error) {
+	data := []byte(cmd.Output)
+	if cmd.OutputFile != "" {
+		b, err := os.ReadFile(cmd.OutputFile)
+		if os.IsNotExist(err) && cmd.Status != StatusSuccess {
+			return Results{}, nil // the Cmd failed before writing it
+		}
+		if err != nil {
+			return Results{}, err
+		}
+		data = b
+	}
+	return p.parse(cmd.Name, data)
+}
+
+// parse returns the Results of the records in data
+func (p *Parser) parse(name string, data []byte) (Results, error) {
+	fields := p.Fields
+	var recs []record
+	var err error
+	switch p.Format {
+	case ParseLines:
+		recs = linesRecords(data)
+		if len(fields) == 0 {
+			return classifyLines(recs), nil
+		}
+	case ParseJSON:
+		recs, err = jsonRecords(data, p.Records)
+	case ParseJSONL:
+		recs = jsonlRecords(data)
+	case ParseRegex:
+		re := regexp.MustCompile(p.Pattern)
+		recs = regexRecords(re, data)
+		if len(fields) == 0 {
+			fields = fieldGroups(re)
+		}
+	case ParseXML:
+		recs, err = xmlRecords(data, p.Records)
+	}
+	if err != nil {
+		return Results{}, err
+	}
+	var res Results
+	for _, rec := range recs {
+		res.add(name, rec, fields)
+	}
+	res.unique()
+	return res, nil
+}
+
+// add appends the values of a record to the Results
+func (r *Results) add(name string, rec record, fields map[string]string) {
+	get := func(f string) []string {
+		path, ok := fields[f]
+		if !ok {
+			if len(fields) > 0 {
+				return nil
+			}
+			path = f
+		}
+		return CleanSlice(rec(path))
+	}
+	doms, ips, urls := get("domain"), get("ip"), get("url")
+	r.Domains = append(r.Domains, doms...)
+	r.IPs = append(r.IPs, ips...)
+	r.URLs = append(r.URLs, urls...)
+	host := first(ips, doms)
+	proto := first(get("proto"))
+	if proto == "" {
+		proto = "tcp"
+	}
+	for _, v := range get("port") {
+		n, err := strconv.Atoi(v)
+		if err == nil && host != "" && n > 0 && n < 65536 {
+			r.Ports = append(r.Ports, Port{Host: host, Port: n, Proto: proto})
+		}
+	}
+	severity := first(get("severity"))
+	target := first(urls, doms, ips)
+	for _, v := range get("finding") {
+		r.Findings = append(r.Findings, Finding{Cmd: name, Target: target, Name: v, Severity: severity})
+	}
+}
+
+// unique removes duplicate results, keeping the first occurrence
+func (r *Results) unique() {
+	r.Domains = UniqueSlice(r.Domains)
+	r.IPs = UniqueSlice(r.IPs)
+	r.URLs = UniqueSlice(r.URLs)
+	seenPorts := make(map[Port]bool)
+	var ports []Port
+	for _, p := range r.Ports {
+		if !seenPorts[p] {
+			seenPorts[p] = true
+			ports = append(ports, p)
+		}
+	}
+	r.Ports = ports
+	seenFindings := make(map[Finding]bool)
+	var findings []Finding
+	for _, f := range r.Findings {
+		if !seenFindings[f] {
+			seenFindings[f] = true
+			findings = append(findings, f)
+		}
+	}
+	r.Findings = findings
+}
+
+// Len returns the number of results
+func (r Results) Len() int {
+	return len(r.Domains) + len(r.IPs) + len(r.Ports) + len(r.URLs) + len(r.Findings)
+}
+
+// first returns the first value of the first non empty list
+func first(lists ...[]string) string {
+	for _, l := range lists {
+		if len(l) > 0 {
+			return l[0]
+		}
+	}
+	return ""
+}
+
+func linesRecords(data []byte) []record {
+	var recs []record
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		recs = append(recs, func(path string) []string {
+			if path == "line" {
+				return []string{line}
+			}
+			return nil
+		})
+	}
+	return recs
+}
+
+// classifyLines sorts lines into ips, urls and domains, other lines are ignored
+func classifyLines(recs []record) Results {
+	var res Results
+	for _, rec := range recs {
+		line := rec("line")[0]
+		switch {
+		case net.ParseIP(line) != nil:
+			res.IPs = append(res.IPs, line)
+		case strings.Contains(line, "://"):
+			res.URLs = append(res.URLs, line)
+		case !strings.ContainsAny(line, " \t/:") && strings.Contains(line, "."):
+			res.Domains = append(res.Domains, strings.TrimSuffix(line, "."))
+		}
+	}
+	res.unique()
+	return res
+}
+
+func regexRecords(re *regexp.Regexp, data []byte) []record {
+	var recs []record
+	names := re.SubexpNames()
+	for _, m := range re.FindAllSubmatch(data, -1) {
+		m := m
+		recs = append(recs, func(path string) []string {
+			for n, name := range names {
+				if name == path && n > 0 && m[n] != nil {
+					return []string{string(m[n])}
+				}
+			}
+			return nil
+		})
+	}
+	return recs
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	err := d.Decode(&v)
+	return v, err
+}
+
+func jsonRecords(data []byte, path string) ([]record, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, errors.New("bad json: " + err.Error())
+	}
+	var recs []record
+	for _, v := range jsonPath(doc, path) {
+		if l, ok := v.([]interface{}); ok && path == "" {
+			for _, e := range l {
+				recs = append(recs, jsonRecord(e))
+			}
+			continue
+		}
+		recs = append(recs, jsonRecord(v))
+	}
+	return recs, nil
+}
+
+func jsonlRecords(data []byte) []record {
+	var recs []record
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		v, err := decodeJSON([]byte(line))
+		if err != nil {
+			continue
+		}
+		recs = append(recs, jsonRecord(v))
+	}
+	return recs
+}
+
+func jsonRecord(v interface{}) record {
+	return func(path string) []string {
+		var vals []string
+		for _, e := range jsonPath(v, path) {
+			switch e := e.(type) {
+			case string:
+				vals = append(vals, e)
+			case json.Number:
+				vals = append(vals, e.String())
+			case bool:
+				vals = append(vals, strconv.FormatBool(e))
+			}
+		}
+		return vals
+	}
+}
+
+// jsonPath returns the values at a dotted path, arrays along the path yield every element unless indexed
+func jsonPath(v interface{}, path string) []interface{} {
+	if path == "" {
+		if l, ok := v.([]interface{}); ok {
+			return l
+		}
+		return []interface{}{v}
+	}
+	key, rest, _ := strings.Cut(path, ".")
+	switch v := v.(type) {
+	case map[string]interface{}:
+		e, ok := v[key]
+		if !ok {
+			return nil
+		}
+		return jsonPath(e, rest)
+	case []interface{}:
+		if n, err := strconv.Atoi(key); err == nil {
+			if n < 0 || n >= len(v) {
+				return nil
+			}
+			return jsonPath(v[n], rest)
+		}
+		var vals []interface{}
+		for _, e := range v {
+			vals = append(vals, jsonPath(e, path)...)
+		}
+		return vals
+	}
+	return nil
+}
+
+// xmlNode is an element of a parsed xml document
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	parent   *xmlNode
+	children []*xmlNode
+}
+
+func parseXML(data []byte) (*xmlNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	root := &xmlNode{}
+	cur := root
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("bad xml: " + err.Error())
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local, attrs: make(map[string]string), parent: cur}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			cur.children = append(cur.children, n)
+			cur = n
+		case xml.EndElement:
+			if cur.parent != nil {
+				cur = cur.parent
+			}
+		case xml.CharData:
+			cur.text += string(t)
+		}
+	}
+	return root, nil
+}
+
+func xmlRecords(data []byte, path string) ([]record, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	var recs []record
+	for _, n := range root.find(path) {
+		n := n
+		recs = append(recs, func(path string) []string {
+			return n.values(path)
+		})
+	}
+	return recs, nil
+}
+
+// find returns the elements at a slash separated path of element names and ..
+func (n *xmlNode) find(path string) []*xmlNode {
+	nodes := []*xmlNode{n}
+	for _, seg := range strings.Split(strings.Trim(path, "/"), "/") {
+		var next []*xmlNode
+		for _, e := range nodes {
+			switch seg {
+			case "", ".":
+				next = append(next, e)
+			case "..":
+				if e.parent != nil {
+					next = append(next, e.parent)
+				}
+			default:
+				for _, c := range e.children {
+					if c.name == seg {
+						next = append(next, c)
+					}
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// values returns the attribute or text of the elements at path, eg: ../address/@addr or hostnames/hostname/@name
+func (n *xmlNode) values(path string) []string {
+	attr := ""
+	if i := strings.LastIndex(path, "@"); i >= 0 {
+		path, attr = strings.TrimSuffix(path[:i], "/"), path[i+1:]
+	}
+	var vals []string
+	for _, e := range n.find(path) {
+		if attr == "" {
+			vals = append(vals, strings.TrimSpace(e.text))
+		} else if v, ok := e.attrs[attr]; ok {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+const nmapXML = `<?xml version="1.0"?>
+<!DOCTYPE nmaprun>
+<nmaprun scanner="nmap">
+  <host>
+    <address addr="10.0.0.1" addrtype="ipv4"/>
+    <hostnames><hostname name="a.example.com" type="PTR"/></hostnames>
+    <ports>
+      <port protocol="tcp" portid="80"><state state="open"/><service name="http"/></port>
+      <port protocol="tcp" portid="443"><state state="open"/><service name="https"/></port>
+    </ports>
+  </host>
+  <host>
+    <address addr="10.0.0.2" addrtype="ipv4"/>
+    <ports>
+      <port protocol="udp" portid="53"><state state="open"/><service name="domain"/></port>
+    </ports>
+  </host>
+</nmaprun>
+`
+
+const nucleiJSONL = `{"template-id":"tech-detect","host":"a.example.com","matched-at":"https://a.example.com/","info":{"name":"Tech","severity":"info"}}
+[INF] not json
+{"template-id":"exposed-git","host":"b.example.com","matched-at":"https://b.example.com/.git","info":{"name":"Git","severity":"high"}}
+
+{"template-id":"exposed-git","host":"b.example.com","matched-at":"https://b.example.com/.git","info":{"name":"Git","severity":"high"}}
+{broken
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Parser
+		data string
+		want Results
+	}{
+		{
+			"lines classified",
+			Parser{Format: ParseLines},
+			"a.example.com\n10.0.0.1\n\nhttps://a.example.com/x\nnot a domain\nb.example.com.\na.example.com\n::1\n",
+			Results{Domains: []string{"a.example.com", "b.example.com"}, IPs: []string{"10.0.0.1", "::1"}, URLs: []string{"https://a.example.com/x"}},
+		},
+		{
+			"lines with fields",
+			Parser{Format: ParseLines, Fields: map[string]string{"url": "line"}},
+			"  https://a.example.com  \nhttps://b.example.com\n",
+			Results{URLs: []string{"https://a.example.com", "https://b.example.com"}},
+		},
+		{
+			"nmap xml",
+			Parser{Format: ParseXML, Records: "nmaprun/host/ports/port", Fields: map[string]string{
+				"port":   "@portid",
+				"proto":  "@protocol",
+				"ip":     "../../address/@addr",
+				"domain": "../../hostnames/hostname/@name",
+			}},
+			nmapXML,
+			Results{
+				Domains: []string{"a.example.com"},
+				IPs:     []string{"10.0.0.1", "10.0.0.2"},
+				Ports:   []Port{{"10.0.0.1", 80, "tcp"}, {"10.0.0.1", 443, "tcp"}, {"10.0.0.2", 53, "udp"}},
+			},
+		},
+		{
+			"xml records from the root",
+			Parser{Format: ParseXML, Records: "/nmaprun/host/", Fields: map[string]string{"ip": "address/@addr", "port": "ports/port/@portid"}},
+			nmapXML,
+			Results{
+				IPs:   []string{"10.0.0.1", "10.0.0.2"},
+				Ports: []Port{{"10.0.0.1", 80, "tcp"}, {"10.0.0.1", 443, "tcp"}, {"10.0.0.2", 53, "tcp"}},
+			},
+		},
+		{
+			"xml text and missing attributes",
+			Parser{Format: ParseXML, Records: "r/e", Fields: map[string]string{"domain": "d", "finding": "@name", "severity": "../@sev"}},
+			`<r sev="low"><e name="x"><d> a.example.com </d></e><e><d>b.example.com</d></e></r>`,
+			Results{
+				Domains:  []string{"a.example.com", "b.example.com"},
+				Findings: []Finding{{Cmd: "t", Target: "a.example.com", Name: "x", Severity: "low"}},
+			},
+		},
+		{
+			"nuclei jsonl",
+			Parser{Format: ParseJSONL, Fields: map[string]string{
+				"domain":   "host",
+				"url":      "matched-at",
+				"finding":  "template-id",
+				"severity": "info.severity",
+			}},
+			nucleiJSONL,
+			Results{
+				Domains: []string{"a.example.com", "b.example.com"},
+				URLs:    []string{"https://a.example.com/", "https://b.example.com/.git"},
+				Findings: []Finding{
+					{Cmd: "t", Target: "https://a.example.com/", Name: "tech-detect", Severity: "info"},
+					{Cmd: "t", Target: "https://b.example.com/.git", Name: "exposed-git", Severity: "high"},
+				},
+			},
+		},
+		{
+			"json array fan-out",
+			Parser{Format: ParseJSON, Records: "data.hosts", Fields: map[string]string{"ip": "ip", "port": "ports.port", "domain": "names"}},
+			`{"data": {"hosts": [
+				{"ip": "10.0.0.1", "names": ["a.example.com", "b.example.com"], "ports": [{"port": 80}, {"port": 443}]},
+				{"ip": "10.0.0.2", "ports": [{"port": 22}, {"port": "8080"}, {"port": 0}, {"port": 70000}, {"port": "x"}]}
+			]}}`,
+			Results{
+				Domains: []string{"a.example.com", "b.example.com"},
+				IPs:     []string{"10.0.0.1", "10.0.0.2"},
+				Ports:   []Port{{"10.0.0.1", 80, "tcp"}, {"10.0.0.1", 443, "tcp"}, {"10.0.0.2", 22, "tcp"}, {"10.0.0.2", 8080, "tcp"}},
+			},
+		},
+		{
+			"json array index",
+			Parser{Format: ParseJSON, Records: "hosts", Fields: map[string]string{"ip": "ips.0", "port": "ports.1"}},
+			`{"hosts": [{"ips": ["10.0.0.1", "10.0.0.9"], "ports": [80, 443]}, {"ips": ["10.0.0.2"], "ports": [22]}]}`,
+			Results{IPs: []string{"10.0.0.1", "10.0.0.2"}, Ports: []Port{{"10.0.0.1", 443, "tcp"}}},
+		},
+		{
+			"json top level array with default fields",
+			Parser{Format: ParseJSON},
+			`[{"domain": "a.example.com", "port": 443, "proto": "tcp"}, {"ip": "10.0.0.1", "port": 53, "proto": "udp"}, {"url": true}]`,
+			Results{
+				Domains: []string{"a.example.com"},
+				IPs:     []string{"10.0.0.1"},
+				Ports:   []Port{{"a.example.com", 443, "tcp"}, {"10.0.0.1", 53, "udp"}},
+				URLs:    []string{"true"},
+			},
+		},
+		{
+			"regex groups",
+			Parser{Format: ParseRegex, Pattern: `(?P<domain>[a-z.]+) \[(?P<ip>[0-9.]+)\]`},
+			"found a.example.com [10.0.0.1]\nfound b.example.com [10.0.0.2]\nnothing here\n",
+			Results{Domains: []string{"a.example.com", "b.example.com"}, IPs: []string{"10.0.0.1", "10.0.0.2"}},
+		},
+		{
+			"regex fields",
+			Parser{Format: ParseRegex, Pattern: `(?P<h>[a-z.]+):(?P<p>\d+)`, Fields: map[string]string{"domain": "h", "port": "p"}},
+			"a.example.com:8443 b.example.com:22",
+			Results{Domains: []string{"a.example.com", "b.example.com"}, Ports: []Port{{"a.example.com", 8443, "tcp"}, {"b.example.com", 22, "tcp"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (Cmd{Parse: &tt.p}).validateParser(); err != nil {
+				t.Fatal(err)
+			}
+			res, err := tt.p.parse("t", []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := fmt.Sprintf("%+v", res), fmt.Sprintf("%+v", tt.want); got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Parser
+		data string
+	}{
+		{"bad json", Parser{Format: ParseJSON}, `{"a": `},
+		{"bad xml", Parser{Format: ParseXML, Records: "r"}, `<r><a></b></r>`},
+	}
+	for _, tt := range tests {
+		if _, err := tt.p.parse("t", []byte(tt.data)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestValidateParser(t *testing.T) {
+	tests := []struct {
+		p   Parser
+		err string
+	}{
+		{Parser{Format: ParseLines}, ""},
+		{Parser{Format: "csv"}, "parse: unknown format csv, use lines, json, jsonl, regex or xml"},
+		{Parser{Format: ParseXML}, "parse: records is required for the xml format, eg: nmaprun/host"},
+		{Parser{Format: ParseRegex, Pattern: "("}, "parse: bad pattern: error parsing regexp: missing closing ): `(`"},
+		{Parser{Format: ParseRegex, Pattern: "(?P<host>.*)"}, "parse: pattern needs a named group like (?P<domain>...) or fields"},
+		{Parser{Format: ParseJSONL, Fields: map[string]string{"host": "h"}}, "parse: unknown field host, use domain, ip, port, proto, url, finding, severity"},
+	}
+	for _, tt := range tests {
+		err := Cmd{Parse: &tt.p}.validateParser()
+		if got := fmt.Sprint(err); (tt.err == "" && err != nil) || (tt.err != "" && got != tt.err) {
+			t.Errorf("%+v: error %v, want %q", tt.p, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// limitsSupported is true where max_memory, max_cpu_time and nice can be applied
+const limitsSupported = true
+
+// setProcAttr starts the Cmd in its own process group so the whole bash subtree can be signalled,
+// and kills it if webrecon itself dies.
+func setProcAttr(run *exec.Cmd) {
+	run.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+}
+
+// signalProcs sends sig to the process group of a started Cmd.
+func signalProcs(run *exec.Cmd, sig syscall.Signal) {
+	if run.Process == nil {
+		return
+	}
+	syscall.Kill(-run.Process.Pid, sig)
+}
+
+// limitedCommand returns the process of a Cmd with its max_memory, max_cpu_time and nice set before it execs,
+// so the processes it starts inherit them from the first instruction on. a cmdline sets them with ulimit at the
+// start of its bash script, args are executed through prlimit. nice is relative to the nice of webrecon.
+func limitedCommand(cmd Cmd) (*exec.Cmd, error) {
+	var mem, secs uint64
+	if cmd.MaxMemory != "" {
+		n, err := parseMemory(cmd.MaxMemory)
+		if err != nil {
+			return nil, err
+		}
+		mem = n
+	}
+	if cmd.MaxCPUTime > 0 {
+		secs = uint64((cmd.MaxCPUTime + time.Second - 1) / time.Second)
+	}
+	// SIGXCPU at the cpu limit, SIGKILL 5s later for processes that ignore it
+	var args []string
+	if len(cmd.Args) == 0 {
+		var ulimits []string
+		if mem > 0 {
+			ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", (mem+1023)/1024)) // in KiB
+		}
+		if secs > 0 {
+			// the soft limit first, it can not be above the hard one
+			ulimits = append(ulimits, fmt.Sprintf("ulimit -S -t %d", secs), fmt.Sprintf("ulimit -H -t %d", secs+5))
+		}
+		script := cmd.CmdLine
+		if len(ulimits) > 0 {
+			script = strings.Join(ulimits, " && ") + " || exit 126\n" + script
+		}
+		args = []string{"bash", "-c", script}
+	} else {
+		args = cmd.Args
+		if mem > 0 || secs > 0 {
+			if _, err := exec.LookPath("prlimit"); err != nil {
+				return nil, errors.New("prlimit is required for max_memory and max_cpu_time with args")
+			}
+			prlimit := []string{"prlimit"}
+			if mem > 0 {
+				prlimit = append(prlimit, fmt.Sprintf("--as=%d", mem))
+			}
+			if secs > 0 {
+				prlimit = append(prlimit, fmt.Sprintf("--cpu=%d:%d", secs, secs+5))
+			}
+			args = append(append(prlimit, "--"), args...)
+		}
+	}
+	if cmd.Nice != 0 {
+		args = append([]string{"nice", "-n", strconv.Itoa(cmd.Nice)}, args...)
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+// limitHit returns the limit that stopped a failed Cmd, max_memory or max_cpu_time, or "" if it failed for another reason.
+// the cpu time of the Cmd includes the processes bash started, a failed allocation is recognised from the output.
+func limitHit(cmd Cmd, state *os.ProcessState, output string) string {
+	if state == nil {
+		return ""
+	}
+	if cmd.MaxCPUTime > 0 {
+		if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+			used := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+			if used >= cmd.MaxCPUTime {
+				return "max_cpu_time"
+			}
+		}
+		if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == syscall.SIGXCPU {
+			return "max_cpu_time"
+		}
+	}
+	if cmd.MaxMemory != "" && outOfMemory.MatchString(output) {
+		return "max_memory"
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitsBeforeExec(t *testing.T) {
+	// the limits are read by the first instruction of the Cmd
+	const show = "ulimit -v; ulimit -S -t; ulimit -H -t; nice"
+	tests := []struct {
+		name string
+		cmd  Cmd
+		want string
+	}{
+		{"cmdline", Cmd{CmdLine: show, MaxMemory: "512M", MaxCPUTime: 90 * time.Second, Nice: 5}, "524288 90 95 5"},
+		{"cmdline memory only", Cmd{CmdLine: "ulimit -v", MaxMemory: "2G"}, "2097152"},
+		{"args", Cmd{Args: []string{"bash", "-c", show}, MaxMemory: "1G", MaxCPUTime: 1500 * time.Millisecond, Nice: 3}, "1048576 2 7 3"},
+		{"args nice only", Cmd{Args: []string{"nice"}, Nice: 2}, "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestRunner()
+			cmd := tt.cmd
+			cmd.Name = "t"
+			c.runProcess(context.Background(), &cmd)
+			if cmd.Status != StatusSuccess {
+				t.Fatalf("status %s: %s", cmd.Status, cmd.Output)
+			}
+			if got := strings.Join(strings.Fields(cmd.Output), " "); got != tt.want {
+				t.Errorf("limits %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitHit(t *testing.T) {
+	c := newTestRunner()
+	cmd := Cmd{Name: "t", CmdLine: "while :; do :; done", MaxCPUTime: time.Second, Timeout: 20 * time.Second}
+	c.runProcess(context.Background(), &cmd)
+	if cmd.Status != StatusLimit {
+		t.Errorf("status %s, want %s: %s", cmd.Status, StatusLimit, cmd.Output)
+	}
+}
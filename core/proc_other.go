@@ -0,0 +1,36 @@
+//go:build !linux
+
+package core
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// limitsSupported is true where max_memory, max_cpu_time and nice can be applied
+const limitsSupported = false
+
+// setProcAttr is a no-op, process groups are only used on linux.
+func setProcAttr(run *exec.Cmd) {}
+
+// signalProcs kills the started process, its children are not tracked outside of linux.
+func signalProcs(run *exec.Cmd, sig syscall.Signal) {
+	if run.Process == nil {
+		return
+	}
+	run.Process.Kill()
+}
+
+// limitedCommand returns the process of a Cmd, validateLimits rejects limits outside of linux.
+func limitedCommand(cmd Cmd) (*exec.Cmd, error) {
+	if len(cmd.Args) > 0 {
+		return exec.Command(cmd.Args[0], cmd.Args[1:]...), nil
+	}
+	return exec.Command("bash", "-c", cmd.CmdLine), nil
+}
+
+// limitHit always returns "", limits are only applied on linux.
+func limitHit(cmd Cmd, state *os.ProcessState, output string) string {
+	return ""
+}
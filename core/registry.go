@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VersionTimeout is how long the version command of a Tool may run
+var VersionTimeout = 10 * time.Second
+
+// Tool is an entry of the tool registry, every tool is checked before a run starts and cmdlines
+// can use its path with {{ tool "amass" }}, eg:
+//
+//	registry:
+//	  - {name: amass, path: /opt/amass/amass, version_args: [-version], min_version: 3.19.0}
+type Tool struct {
+	Name        string   `yaml:"name"`
+	Path        string   `yaml:"path"`         // Path of the binary, a name without a slash is looked up in PATH
+	VersionArgs []string `yaml:"version_args"` // VersionArgs make the tool print its version, eg: [-version]
+	MinVersion  string   `yaml:"min_version"`  // MinVersion is the oldest usable version, eg: 3.19.0, requires VersionArgs
+}
+
+// ToolInfo is the path and version a Tool was found with, recorded in the run metadata
+type ToolInfo struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+}
+
+var dottedVersion = regexp.MustCompile(`[0-9]+(\.[0-9]+)+`)
+var plainVersion = regexp.MustCompile(`[0-9]+`)
+var validVersion = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+// validateRegistry checks every Tool has a unique name and a path
+func validateRegistry(tools []Tool) error {
+	seen := make(map[string]bool)
+	for _, t := range tools {
+		if t.Name == "" {
+			return errors.New("registry: every tool requires a name")
+		}
+		if seen[t.Name] {
+			return errors.New("registry: duplicate tool " + t.Name)
+		}
+		seen[t.Name] = true
+		if t.Path == "" {
+			return errors.New("registry: " + t.Name + " requires a path")
+		}
+		if t.MinVersion != "" && !validVersion.MatchString(t.MinVersion) {
+			return errors.New("registry: " + t.Name + ": min_version " + t.MinVersion + " is not a version like 3.19.0")
+		}
+		if t.MinVersion != "" && len(t.VersionArgs) == 0 {
+			return errors.New("registry: " + t.Name + ": min_version requires version_args")
+		}
+	}
+	return nil
+}
+
+// CheckTools finds the binary of every Tool and reads its version, and returns what was found keyed by name.
+// tools that are missing or too old are returned with their configured path, and their problems are reported together.
+func CheckTools(tools []Tool) (map[string]ToolInfo, error) {
+	found := make(map[string]ToolInfo)
+	var problems []string
+	for _, t := range tools {
+		info, err := t.check()
+		if err != nil {
+			problems = append(problems, t.Name+": "+err.Error())
+		}
+		found[t.Name] = info
+	}
+	if len(problems) > 0 {
+		return found, errors.New("tool preflight failed:\n" + strings.Join(problems, "\n"))
+	}
+	return found, nil
+}
+
+// check looks up the binary of a Tool and runs its version command
+func (t Tool) check() (ToolInfo, error) {
+	info := ToolInfo{Path: t.Path}
+	path, err := exec.LookPath(t.Path)
+	if err != nil {
+		return info, errors.New(t.Path + " is not installed or not executable")
+	}
+	info.Path = path
+	if len(t.VersionArgs) == 0 {
+		return info, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), VersionTimeout)
+	defer cancel()
+	// many tools exit non zero after printing their version, so only the output is used
+	out, _ := exec.CommandContext(ctx, path, t.VersionArgs...).CombinedOutput()
+	info.Version = dottedVersion.FindString(string(out))
+	if info.Version == "" {
+		info.Version = plainVersion.FindString(string(out))
+	}
+	Dprint("tool", t.Name, "at", path, "version", info.Version)
+	if t.MinVersion == "" {
+		return info, nil
+	}
+	if info.Version == "" {
+		return info, errors.New("no version in the output of " + path + " " + strings.Join(t.VersionArgs, " "))
+	}
+	if compareVersions(info.Version, t.MinVersion) < 0 {
+		return info, errors.New("version " + info.Version + " is older than min_version " + t.MinVersion)
+	}
+	return info, nil
+}
+
+// compareVersions compares dotted versions number by number, missing numbers are 0, so 3.19 equals 3.19.0
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
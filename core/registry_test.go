@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.19.0", "3.19.0", 0},
+		{"3.19", "3.19.0", 0},
+		{"3.9.1", "3.19.0", -1},
+		{"3.19.1", "3.19", 1},
+		{"10", "9.9.9", 1},
+		{"2.0.0", "2.0.0.1", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTools(t *testing.T) {
+	dir := t.TempDir()
+	// fake tools print their version like the real ones do, some exit non zero after it
+	scripts := map[string]string{
+		"amass":    "echo 'v3.21.2'; exit 1",
+		"old":      "echo 'old version 3.9.1 (go1.20)'",
+		"plain":    "echo 'plain 7'",
+		"silent":   "true",
+		"stderr":   "echo 'stderr 1.2.3' >&2",
+		"inpath":   "echo 'inpath 2.0'",
+		"argcheck": `[ "$1" = -V ] && echo 1.0.0`,
+	}
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "noexec"), []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	path := func(name string) string { return filepath.Join(dir, name) }
+	tests := []struct {
+		tool Tool
+		info string
+		err  string
+	}{
+		{Tool{Name: "amass", Path: path("amass"), VersionArgs: []string{"-version"}, MinVersion: "3.19.0"}, path("amass") + " 3.21.2", ""},
+		{Tool{Name: "old", Path: path("old"), VersionArgs: []string{"-version"}, MinVersion: "3.19.0"}, path("old") + " 3.9.1", "version 3.9.1 is older than min_version 3.19.0"},
+		{Tool{Name: "plain", Path: path("plain"), VersionArgs: []string{"-v"}, MinVersion: "7"}, path("plain") + " 7", ""},
+		{Tool{Name: "silent", Path: path("silent"), VersionArgs: []string{"-v"}, MinVersion: "1.0"}, path("silent") + " ", "no version in the output of " + path("silent") + " -v"},
+		{Tool{Name: "silent", Path: path("silent"), VersionArgs: []string{"-v"}}, path("silent") + " ", ""},
+		{Tool{Name: "stderr", Path: path("stderr"), VersionArgs: []string{"-v"}, MinVersion: "1.2"}, path("stderr") + " 1.2.3", ""},
+		{Tool{Name: "argcheck", Path: path("argcheck"), VersionArgs: []string{"-V"}, MinVersion: "1.0.0"}, path("argcheck") + " 1.0.0", ""},
+		{Tool{Name: "inpath", Path: "inpath"}, path("inpath") + " ", ""},
+		{Tool{Name: "missing", Path: path("missing"), VersionArgs: []string{"-v"}}, path("missing") + " ", path("missing") + " is not installed or not executable"},
+		{Tool{Name: "missing", Path: "wr-missing-tool"}, "wr-missing-tool ", "wr-missing-tool is not installed or not executable"},
+		{Tool{Name: "noexec", Path: path("noexec")}, path("noexec") + " ", path("noexec") + " is not installed or not executable"},
+	}
+	for _, tt := range tests {
+		found, err := CheckTools([]Tool{tt.tool})
+		want := ""
+		if tt.err != "" {
+			want = "tool preflight failed:\n" + tt.tool.Name + ": " + tt.err
+		}
+		if got := fmt.Sprint(err); (want == "" && err != nil) || (want != "" && got != want) {
+			t.Errorf("%s: error %v, want %q", tt.tool.Path, err, want)
+		}
+		info := found[tt.tool.Name]
+		if got := info.Path + " " + info.Version; got != tt.info {
+			t.Errorf("%s: found %q, want %q", tt.tool.Path, got, tt.info)
+		}
+	}
+
+	// the problems of every tool are reported together, and the tools that were found are returned
+	found, err := CheckTools([]Tool{
+		{Name: "amass", Path: path("amass"), VersionArgs: []string{"-version"}},
+		{Name: "a", Path: path("missing")},
+		{Name: "b", Path: path("old"), VersionArgs: []string{"-version"}, MinVersion: "4"},
+	})
+	if err == nil || strings.Count(err.Error(), "\n") != 2 || len(found) != 3 || found["amass"].Version != "3.21.2" {
+		t.Errorf("found %v, error %v", found, err)
+	}
+}
+
+func TestValidateRegistry(t *testing.T) {
+	tests := []struct {
+		tools []Tool
+		err   string
+	}{
+		{[]Tool{{Name: "a", Path: "a"}, {Name: "b", Path: "/b", VersionArgs: []string{"-v"}, MinVersion: "1.2"}}, ""},
+		{[]Tool{{Path: "a"}}, "registry: every tool requires a name"},
+		{[]Tool{{Name: "a", Path: "a"}, {Name: "a", Path: "b"}}, "registry: duplicate tool a"},
+		{[]Tool{{Name: "a"}}, "registry: a requires a path"},
+		{[]Tool{{Name: "a", Path: "a", VersionArgs: []string{"-v"}, MinVersion: "v1.2"}}, "registry: a: min_version v1.2 is not a version like 3.19.0"},
+		{[]Tool{{Name: "a", Path: "a", MinVersion: "1.2"}}, "registry: a: min_version requires version_args"},
+	}
+	for _, tt := range tests {
+		err := validateRegistry(tt.tools)
+		if got := fmt.Sprint(err); (tt.err == "" && err != nil) || (tt.err != "" && got != tt.err) {
+			t.Errorf("%+v: error %v, want %q", tt.tools, err, tt.err)
+		}
+	}
+}
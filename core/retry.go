@@ -0,0 +1,89 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"time"
+)
+
+// DefaultRetryBackoff is used when a Cmd has Retries but no RetryBackoff
+var DefaultRetryBackoff = 5 * time.Second
+
+// RetryOn selects the failures that are retried, a failure matching either the exit codes or the output is retried.
+type RetryOn struct {
+	ExitCodes []int  `yaml:"exit_codes"` // eg: [1, 75]
+	Output    string `yaml:"output"`     // regex matched against the output, eg: "(?i)rate limit|429"
+}
+
+// Attempt is the result of one execution of a Cmd
+type Attempt struct {
+	Attempt  int       `json:"attempt"`
+	Status   string    `json:"status"`
+	ExitCode int       `json:"exit_code"`
+	Output   string    `json:"output"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Cached   bool      `json:"cached,omitempty"` // Cached is set when the result was reused from the Cache
+}
+
+func (r RetryOn) empty() bool {
+	return len(r.ExitCodes) == 0 && r.Output == ""
+}
+
+// validateRetry checks the retry settings of a Cmd
+func (cmd Cmd) validateRetry() error {
+	if cmd.Retries < 0 {
+		return errors.New("retries can not be negative")
+	}
+	if cmd.RetryOn.Output != "" {
+		if _, err := regexp.Compile(cmd.RetryOn.Output); err != nil {
+			return errors.New("invalid retry_on output regex: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// shouldRetry reports if the last attempt of a Cmd failed in a way its RetryOn policy retries.
+func (cmd Cmd) shouldRetry() bool {
+	if cmd.Status != StatusError && cmd.Status != StatusTimeout && cmd.Status != StatusUnverified {
+		return false
+	}
+	if cmd.RetryOn.empty() {
+		return true
+	}
+	for _, code := range cmd.RetryOn.ExitCodes {
+		if code == cmd.ExitCode {
+			return true
+		}
+	}
+	if cmd.RetryOn.Output != "" {
+		if re, err := regexp.Compile(cmd.RetryOn.Output); err == nil && re.MatchString(cmd.Output) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the retry following attempt n
+func (cmd Cmd) backoff(n int) time.Duration {
+	d := cmd.RetryBackoff
+	if d <= 0 {
+		d = DefaultRetryBackoff
+	}
+	for i := 1; i < n && d < time.Hour; i++ {
+		d *= 2
+	}
+	return d
+}
+
+// resetOutputFile empties the output file of a Cmd before a retry, so an appending cmdline, eg: tee -a,
+// does not keep the output of the failed attempts
+func (cmd Cmd) resetOutputFile() {
+	if cmd.OutputFile == "" {
+		return
+	}
+	if err := os.Truncate(cmd.OutputFile, 0); err != nil && !os.IsNotExist(err) {
+		Eprint("failed to reset the output file of", cmd.Name, err)
+	}
+}
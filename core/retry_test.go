@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		n       int
+		want    time.Duration
+	}{
+		{0, 1, DefaultRetryBackoff},
+		{0, 2, 2 * DefaultRetryBackoff},
+		{time.Second, 1, time.Second},
+		{time.Second, 3, 4 * time.Second},
+		{time.Second, 20, 4096 * time.Second}, // doubling stops past an hour
+	}
+	for _, tt := range tests {
+		if got := (Cmd{RetryBackoff: tt.backoff}).backoff(tt.n); got != tt.want {
+			t.Errorf("backoff %v attempt %d: %v, want %v", tt.backoff, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	// every attempt counts itself in Counter and appends to the output file, the third one succeeds
+	third := `echo x >> {{ .Counter }}; n=$(wc -l < {{ .Counter }}); echo attempt $n | tee -a {{ .OutFile }}; [ $n -ge 3 ]`
+	tests := []struct {
+		name     string
+		cmdline  string
+		retries  int
+		retryOn  RetryOn
+		attempts int
+		status   string
+		output   string // of the output file
+	}{
+		{"no retries", "exit 1", 0, RetryOn{}, 1, StatusError, ""},
+		{"retries run out", "exit 1", 2, RetryOn{}, 3, StatusError, ""},
+		{"success on a retry", third, 5, RetryOn{}, 3, StatusSuccess, "attempt 3\n"},
+		{"last attempt fails", third, 1, RetryOn{}, 2, StatusError, "attempt 2\n"},
+		{"exit code not retried", "exit 3", 2, RetryOn{ExitCodes: []int{75}}, 1, StatusError, ""},
+		{"exit code retried", "exit 75", 2, RetryOn{ExitCodes: []int{75}}, 3, StatusError, ""},
+		{"output retried", "echo rate limit; exit 1", 1, RetryOn{Output: "rate limit"}, 2, StatusError, ""},
+		{"output not retried", "echo denied; exit 1", 1, RetryOn{Output: "rate limit"}, 1, StatusError, ""},
+		{"success not retried", "true", 2, RetryOn{}, 1, StatusSuccess, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := newTestRunner()
+			c.VarMap["Counter"] = func(*Cmd) string { return filepath.Join(dir, "counter") }
+			c.VarMap["OutFile"] = func(cmd *Cmd) string {
+				cmd.OutputFile = filepath.Join(dir, "out")
+				return cmd.OutputFile
+			}
+			// the comment renders OutFile, so every Cmd has an output file
+			r := Runners{{Name: "t", CmdLine: tt.cmdline + " #{{ .OutFile }}", CallBack: "done",
+				Retries: tt.retries, RetryBackoff: time.Millisecond, RetryOn: tt.retryOn}}
+			if err := c.Run(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			cmd := c.Completed[0]
+			if len(cmd.Attempts) != tt.attempts || cmd.Status != tt.status {
+				t.Errorf("%d attempt(s) status %s, want %d %s", len(cmd.Attempts), cmd.Status, tt.attempts, tt.status)
+			}
+			for n, a := range cmd.Attempts {
+				if a.Attempt != n+1 {
+					t.Errorf("attempt %d is numbered %d", n+1, a.Attempt)
+				}
+			}
+			if b, _ := os.ReadFile(cmd.OutputFile); string(b) != tt.output {
+				t.Errorf("output file %q, want %q", b, tt.output)
+			}
+		})
+	}
+}
@@ -1,216 +1,671 @@
 package core
 
 import (
+	"context"
 	"errors"
-	"os/exec"
-	"regexp"
+	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
+// KillGrace is how long a timed out or cancelled Cmd gets to exit after SIGTERM, before it is sent SIGKILL.
+var KillGrace = 5 * time.Second
+
 type CmdRunner struct {
-	CallBacks  CallBacks // CallBacks is a map[string]CbFunc, used to set callbacks for runners
-	VarMap     VarMap    // VarMap is a map[string]String, used to set replacement variables for runners.
-	MaxThreads int       // MaxThreads sets the max number of concurrent threads for this CmdRunner
-	RunningQ   Queue     // RunningQ is a map[int]Cmd of currently running Cmds
-	WaitingQ   Queue     // WaitingQ is a map[int]Cmd of Cmds currently in the wait Queue
+	CallBacks     CallBacks                // CallBacks is a map[string]CbFunc, used to set callbacks for runners
+	LineCallBacks LineCallBacks            // LineCallBacks is a map[string]LineFunc, used to stream the output of runners line by line
+	VarMap        VarMap                   // VarMap is a map[string]String, used to set replacement variables for runners.
+	MaxThreads    int                      // MaxThreads sets the max number of concurrent threads for this CmdRunner
+	Pools         map[string]int           // Pools maps a pool name to the max number of its Cmds running at once
+	Items         ItemMap                  // Items maps a foreach name to the function returning its items
+	Tools         map[string]string        // Tools maps a registry tool name to its path, for {{ tool "name" }}
+	RunningQ      Queue                    // RunningQ is a map[int]Cmd of currently running Cmds, use GetStatus while the runner is running
+	WaitingQ      Queue                    // WaitingQ is a map[int]Cmd of Cmds currently in the wait Queue, use GetStatus while the runner is running
+	Completed     []Cmd                    // Completed holds every Cmd that finished, with its final Status and Output
+	OnDone        func(Cmd)                // OnDone is called after each Cmd and its callback complete, may be nil
+	OnResults     func(Cmd, Results) error // OnResults is called with the Results parsed from Cmds with a Parser, may be nil
+	Journal       *Journal                 // Journal records every execution of a Cmd, may be nil
+	Cache         *Cache                   // Cache holds the results of Cmds with a CacheTTL, may be nil
+	Name          string                   // Name of the stage this CmdRunner executes, used to resolve depends_on
+	Prior         StatusMap                // Prior holds the Status of Cmds that completed before this CmdRunner started, keyed stage/name
+	DryRun        bool                     // DryRun renders and checks the Cmds without running them or their callbacks
+	mu            sync.Mutex
+	status        StatusMap                  // status of the Cmds completed by this CmdRunner, keyed stage/name
+	fanOuts       map[string]*fanOut         // foreach and chunked Cmds waiting on their jobs, keyed by name
+	blocked       []Cmd                      // Cmds waiting on their dependencies
+	lastQID       int                        // QIDs are handed out in increasing order, so the lowest waiting QID is the oldest
+	pending       int                        // Cmds added to a Run that did not complete yet
+	idle          chan struct{}              // closed when pending drops to 0, nil when the runner is not running
+	sequential    bool                       // set by RunWait, Cmds start one at a time in dependency order
+	paused        bool                       // no Cmds are started while paused, see Pause
+	cancels       map[int]context.CancelFunc // cancel the context of each running Cmd, keyed by QID
+	ctx           context.Context
 }
 
 type Runners []Cmd
 type Cmd struct {
-	Name       string `yaml:"name"`
-	CmdLine    string `yaml:"cmdline"`
-	CallBack   string `yaml:"callback"`
-	Status     string
-	Output     string
-	OutputFile string
-	QID        int
+	Name             string                 `yaml:"name"`
+	CmdLine          string                 `yaml:"cmdline"` // CmdLine is run with bash -c, every variable is shell quoted
+	Args             []string               `yaml:"args"`    // Args is used instead of CmdLine to run a program directly without a shell
+	CallBack         string                 `yaml:"callback"`
+	Stream           string                 `yaml:"stream"`             // Stream names a LineCallBacks entry that gets every output line while the Cmd runs
+	DependsOn        []string               `yaml:"depends_on"`         // DependsOn lists Cmds that must succeed first, as name for the same stage or stage/name
+	Timeout          time.Duration          `yaml:"timeout"`            // Timeout kills the Cmd if it runs longer, eg: 30m
+	Retries          int                    `yaml:"retries"`            // Retries is how many times a failed Cmd is run again
+	RetryBackoff     time.Duration          `yaml:"retry_backoff"`      // RetryBackoff is the wait before the first retry, doubled for every retry after it
+	RetryOn          RetryOn                `yaml:"retry_on"`           // RetryOn limits retries to some failures, any failure is retried when empty
+	Env              map[string]string      `yaml:"env"`                // Env is added to the environment of the Cmd, values are templates like the cmdline
+	WorkDir          string                 `yaml:"workdir"`            // WorkDir is the directory the Cmd runs in, the current directory when empty
+	MaxMemory        string                 `yaml:"max_memory"`         // MaxMemory limits the address space of every process of the Cmd, eg: 512M or 2G, linux only
+	MaxCPUTime       time.Duration          `yaml:"max_cpu_time"`       // MaxCPUTime limits the CPU time of every process of the Cmd, eg: 10m, linux only
+	Nice             int                    `yaml:"nice"`               // Nice is the scheduling priority of the Cmd, from -20 to 19, linux only
+	Priority         int                    `yaml:"priority"`           // Priority orders the wait queue, higher first, Cmds with the same priority start in queue order
+	Weight           int                    `yaml:"weight"`             // Weight is how many of the MaxThreads the Cmd uses while it runs, 1 when not set
+	Pool             string                 `yaml:"pool"`               // Pool names a CmdRunner.Pools entry that limits how many Cmds of the pool run at once
+	Foreach          string                 `yaml:"foreach"`            // Foreach names a CmdRunner.Items list, the Cmd is run once per item with {{ .Item }} set to it
+	ChunkSize        int                    `yaml:"chunk_size"`         // ChunkSize splits the file of ChunkVar into files of ChunkSize lines, each run as its own job
+	ChunkVar         string                 `yaml:"chunk_var"`          // ChunkVar is the VarMap entry that generates the target file to split, eg: DomsIPFile
+	CacheTTL         time.Duration          `yaml:"cache_ttl"`          // CacheTTL reuses the result of an identical Cmd that succeeded within it, see Cache
+	Parse            *Parser                `yaml:"parse"`              // Parse turns the output into Results passed to CmdRunner.OnResults, the callback is optional with it
+	SuccessExitCodes []int                  `yaml:"success_exit_codes"` // SuccessExitCodes are the exit codes of a success, [0] when empty
+	ExpectOutputFile bool                   `yaml:"expect_output_file"` // ExpectOutputFile fails a Cmd that did not write a non empty output file
+	MinOutputLines   int                    `yaml:"min_output_lines"`   // MinOutputLines fails a Cmd with fewer lines in its output file, or output when it has none
+	FailOnOutput     string                 `yaml:"fail_on_output"`     // FailOnOutput is a regex that fails a Cmd when it matches the output, eg: "(?i)invalid api key"
+	Use              string                 `yaml:"use"`                // Use names a Config.Recon.Tools template the Cmd is made from, see ToolTemplate
+	With             map[string]interface{} `yaml:"with"`               // With sets the params of the Use template
+	Status           string
+	ExitCode         int       // ExitCode of the last attempt, -1 if it could not be started or was killed
+	Attempts         []Attempt // Attempts records every execution of the Cmd
+	Output           string
+	OutputFile       string
+	QID              int
+	Item             string                 // Item is the foreach item of a job expanded from a foreach Cmd
+	Parent           string                 // Parent is the name of the foreach Cmd a job was expanded from
+	Overrides        map[string]string      // Overrides replaces the value of VarMap entries for a job, eg: ChunkVar with the file of a chunk
+	Params           map[string]interface{} `yaml:"-"` // Params are the values of the template params, {{ .Params.name }}
+	vars             map[string]string      // vars are the values of the VarMap entries the Cmd was rendered with
+	entry            map[string]interface{} // entry holds the keys set on a yaml entry with use, see UseTool
 }
 
+// Cmd.Status values
+const (
+	StatusQueued     = "queued"
+	StatusRunning    = "running"
+	StatusSuccess    = "success"
+	StatusError      = "error"
+	StatusSkipped    = "skipped"    // a dependency did not succeed
+	StatusTimeout    = "timeout"    // the Cmd ran longer than its Timeout and was killed
+	StatusCancelled  = "cancelled"  // the run was cancelled before or while the Cmd ran
+	StatusPlanned    = "planned"    // the Cmd was rendered by a dry run, and not executed
+	StatusLimit      = "limit"      // the Cmd was stopped by its max_memory or max_cpu_time
+	StatusUnverified = "unverified" // the Cmd exited with a success code, but its output failed expect_output_file, min_output_lines or fail_on_output
+)
+
+// StatusMap maps stage/name keys to a Cmd Status
+type StatusMap map[string]string
+
 type Queue map[int]Cmd
 
 type CbFunc func(c Cmd) error
 type CallBacks map[string]CbFunc
 
+// LineFunc is called with each line of output while a Cmd runs, stream is StreamStdout or StreamStderr.
+// it is called from the goroutine reading the output, so a slow LineFunc slows down the Cmd.
+type LineFunc func(c Cmd, stream string, line string)
+type LineCallBacks map[string]LineFunc
+
 type VarFunc func(c *Cmd) string
 type VarMap map[string]VarFunc
 
-var wg sync.WaitGroup
-
 // NewCmdRunner initializes a the module, and returns a CmdRunner
-func NewCmdRunner() CmdRunner {
-	var ret CmdRunner
+func NewCmdRunner() *CmdRunner {
+	ret := new(CmdRunner)
 	ret.CallBacks = make(CallBacks)
+	ret.LineCallBacks = make(LineCallBacks)
 	ret.VarMap = make(VarMap)
 	ret.RunningQ = make(Queue)
 	ret.WaitingQ = make(Queue)
+	ret.Prior = make(StatusMap)
+	ret.Items = make(ItemMap)
+	ret.status = make(StatusMap)
+	ret.fanOuts = make(map[string]*fanOut)
+	ret.cancels = make(map[int]context.CancelFunc)
+	ret.ctx = context.Background()
 	return ret
 }
 
+// DepKey returns the stage/name key of a dependency, names without a stage refer to the same stage.
+func DepKey(stage, dep string) string {
+	if strings.Contains(dep, "/") {
+		return dep
+	}
+	return stage + "/" + dep
+}
+
+// validateRunner checks the dependencies, callbacks and cmdline templates of every Cmd, the errors of all Cmds are reported together.
 func (c *CmdRunner) validateRunner(r Runners) error {
+	err := c.validateDeps(r)
+	if err != nil {
+		return err
+	}
+	var errs []string
 	for _, i := range r {
-		if i.CallBack != "none" {
-			if _, ok := c.CallBacks[i.CallBack]; !ok {
-				return errors.New(i.CallBack + " is not in CmdRunner.CallBacks")
-			}
-		}
-		re := regexp.MustCompile("{{ .(.*?) }}")
-		matches := re.FindAllStringSubmatch(i.CmdLine, -1)
-		// fmt.Println(matches)
-		for _, m := range matches {
-			if _, ok := c.VarMap[m[1]]; !ok {
-				return errors.New(m[1] + " is not in CmdRunner.VarMap")
-			}
+		for _, p := range c.checkCmd(i) {
+			errs = append(errs, i.Name+": "+p)
 		}
 	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
 	return nil
 }
 
+// Validate checks the Cmds like Run does before it starts any of them, without running anything.
+// Prior must hold the Cmds of the earlier stages the Cmds depend on.
+func (c *CmdRunner) Validate(r Runners) error {
+	return c.validateRunner(r)
+}
+
+// checkCmd returns the problems with the callbacks, cmdline template and retry settings of a Cmd
+func (c *CmdRunner) checkCmd(i Cmd) []string {
+	var problems []string
+	if i.hasCallBack() {
+		if _, ok := c.CallBacks[i.CallBack]; !ok {
+			problems = append(problems, i.CallBack+" is not in CmdRunner.CallBacks")
+		}
+	}
+	if i.Stream != "" {
+		if _, ok := c.LineCallBacks[i.Stream]; !ok {
+			problems = append(problems, i.Stream+" is not in CmdRunner.LineCallBacks")
+		}
+	}
+	err := c.validateTemplate(i)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	err = i.validateRetry()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	err = i.validateLimits()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if i.Weight < 0 {
+		problems = append(problems, "weight can not be negative")
+	}
+	if _, ok := c.Pools[i.Pool]; i.Pool != "" && !ok {
+		problems = append(problems, i.Pool+" is not in CmdRunner.Pools")
+	}
+	if _, ok := c.Items[i.Foreach]; i.Foreach != "" && !ok {
+		problems = append(problems, "foreach "+i.Foreach+" is not in CmdRunner.Items")
+	}
+	err = c.validateChunks(i)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	err = i.validateChecks()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	err = i.validateParser()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
 // Run runs commands with threads, and waits for them all to finish.  each thread will call its callback define in CallBacks upon completion.
-func (c *CmdRunner) Run(r Runners) error {
+// Cmds with depends_on are started once all their dependencies succeeded, and skipped if one of them did not.
+// when ctx is cancelled running Cmds are killed, queued Cmds are not started, and ctx.Err() is returned.
+// with DryRun set the Cmds are only rendered and checked, see dryRun.
+func (c *CmdRunner) Run(ctx context.Context, r Runners) error {
+	c.mu.Lock()
+	c.ctx = ctx // read under mu by release, doNextRunner and the controls
+	c.mu.Unlock()
+	c.dropPrior(r)
+	if c.DryRun {
+		return c.dryRun(r)
+	}
 	err := c.validateRunner(r)
 	if err != nil {
 		return err
 	}
-	for _, i := range r {
-		c.runOrQueue(i)
+	if c.sequential {
+		r, err = c.sortDeps(r)
+		if err != nil {
+			return err
+		}
 	}
-	wg.Wait()
-	return nil
+	if len(r) == 0 {
+		return ctx.Err()
+	}
+	c.mu.Lock()
+	c.blocked = append(c.blocked, r...)
+	c.pending += len(r)
+	idle := make(chan struct{})
+	c.idle = idle
+	c.mu.Unlock()
+	c.release()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		// nothing may be running to notice a paused or blocked queue was cancelled
+		c.release()
+		c.doNextRunner()
+		<-idle
+	}
+	return ctx.Err()
 }
 
-// RunWait runs commands one by one waiting for each to finish.
-func (c *CmdRunner) RunWait(r Runners) error {
-	err := c.validateRunner(r)
+// RunWait runs commands one by one waiting for each to finish, in dependency order.
+// when ctx is cancelled the running Cmd is killed, the rest are not started, and ctx.Err() is returned.
+// with DryRun set the Cmds are only rendered and checked, see dryRun.
+func (c *CmdRunner) RunWait(ctx context.Context, r Runners) error {
+	c.mu.Lock()
+	c.sequential = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.sequential = false // a later Run of the runner is parallel again
+		c.mu.Unlock()
+	}()
+	return c.Run(ctx, r)
+}
+
+// cancel marks a Cmd that was never started as cancelled
+func (c *CmdRunner) cancel(cmd Cmd) {
+	cmd.Status = StatusCancelled
+	cmd.Output = "run cancelled before the command started"
+	c.addCompleted(cmd)
+}
+
+// execute runs a single Cmd and its callback, and returns it with the final Status and Output.
+// a failed Cmd is run again up to Retries times when its RetryOn policy matches, see shouldRetry.
+func (c *CmdRunner) execute(ctx context.Context, cmd Cmd) Cmd {
+	err := c.parseVars(&cmd)
 	if err != nil {
-		return err
+		cmd.Status = StatusError
+		cmd.Output = "failed to render cmdline: " + err.Error()
+		return cmd
 	}
-	for _, i := range r {
-		c.parseVars(&i)
-		run := exec.Command("bash", "-c", i.CmdLine)
-		out, err := run.CombinedOutput()
+	var key string
+	if c.Cache != nil && cmd.CacheTTL > 0 {
+		key = cacheKey(cmd)
+	}
+	cached := key != "" && c.fromCache(&cmd, key)
+	for n := 1; !cached; n++ {
+		if n > 1 {
+			cmd.resetOutputFile()
+		}
+		a := Attempt{Attempt: n, Started: time.Now()}
+		c.runProcess(ctx, &cmd)
+		a.Finished = time.Now()
+		a.Status = cmd.Status
+		a.ExitCode = cmd.ExitCode
+		a.Output = cmd.Output
+		cmd.Attempts = append(cmd.Attempts, a)
+		if n > cmd.Retries || !cmd.shouldRetry() {
+			break
+		}
+		c.journal(cmd, a, "retried")
+		wait := cmd.backoff(n)
+		Dprint("retrying", cmd.Name, "attempt", n+1, "of", cmd.Retries+1, "in", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			cmd.Status = StatusCancelled
+			c.journal(cmd, a, "cancelled before retry")
+			return cmd
+		}
+	}
+	last := cmd.Attempts[len(cmd.Attempts)-1]
+	if key != "" && !cached && cmd.Status == StatusSuccess {
+		if err := c.Cache.put(key, cmd); err != nil {
+			Eprint("failed to cache", cmd.Name, err)
+		}
+	}
+	if cmd.Status == StatusCancelled {
+		c.journal(cmd, last, "cancelled")
+		return cmd // no callbacks while shutting down
+	}
+	cbResult, err := c.callBack(cmd)
+	if err != nil {
+		cmd.Output = "callback failed"
+		cmd.Status = StatusError
+	}
+	c.journal(cmd, last, cbResult)
+	return cmd
+}
+
+// hasCallBack reports if a Cmd names a callback, a Cmd with a Parser does not need one
+func (i Cmd) hasCallBack() bool {
+	return i.CallBack != "none" && (i.CallBack != "" || i.Parse == nil)
+}
+
+// callBack passes the Results of a Cmd with a Parser to OnResults and calls its callback,
+// and returns the callback result for the journal.
+func (c *CmdRunner) callBack(cmd Cmd) (string, error) {
+	var parsed string
+	if cmd.Parse != nil {
+		res, err := cmd.Parse.parseOutput(cmd)
+		if err == nil && c.OnResults != nil {
+			err = c.OnResults(cmd, res)
+		}
 		if err != nil {
-			i.Output = string(out)
-			i.Status = "error"
-		} else {
-			i.Output = string(out)
-			i.Status = "success"
-		}
-		if i.CallBack != "none" {
-			err := c.CallBacks[i.CallBack](i)
-			if err != nil {
-				i.Output = "callback failed"
-				i.Status = "error"
-			}
+			return "error: parse: " + err.Error(), err
 		}
+		parsed = fmt.Sprintf("parsed %d result(s)", res.Len())
+	}
+	if !cmd.hasCallBack() {
+		if parsed == "" {
+			return "none", nil
+		}
+		return parsed, nil
+	}
+	err := c.CallBacks[cmd.CallBack](cmd)
+	if err != nil {
+		return "error: " + err.Error(), err
+	}
+	if parsed != "" {
+		return "ok, " + parsed, nil
+	}
+	return "ok", nil
+}
 
+// fromCache sets the result of a Cmd from the Cache, and reports if there was a usable entry for key
+func (c *CmdRunner) fromCache(cmd *Cmd, key string) bool {
+	e, ok := c.Cache.get(key, cmd.CacheTTL)
+	if !ok {
+		return false
 	}
-	return nil
+	Dprint("using the cached result of", cmd.Name, "from", e.Finished)
+	now := time.Now()
+	cmd.Status = StatusSuccess
+	cmd.ExitCode = e.ExitCode
+	cmd.Output = e.Output
+	cmd.OutputFile = e.OutputFile
+	cmd.Attempts = append(cmd.Attempts, Attempt{Attempt: 1, Status: StatusSuccess, ExitCode: e.ExitCode, Output: e.Output, Started: now, Finished: now, Cached: true})
+	return true
 }
 
-func (c *CmdRunner) parseVars(cmd *Cmd) {
-	for k := range c.VarMap {
-		cmd.CmdLine = strings.ReplaceAll(cmd.CmdLine, "{{ ."+k+" }}", c.VarMap[k](cmd))
+// journal appends an attempt of a Cmd to the Journal, if the runner has one
+func (c *CmdRunner) journal(cmd Cmd, a Attempt, cbResult string) {
+	if c.Journal == nil {
+		return
+	}
+	err := c.Journal.Record(c.Name, cmd, a, cbResult)
+	if err != nil {
+		Eprint("failed to write journal:", err)
 	}
 }
 
-func (c *CmdRunner) startRunner(cmd Cmd) {
-	c.parseVars(&cmd)
-	run := exec.Command("bash", "-c", cmd.CmdLine)
-	out, err := run.CombinedOutput()
+// runProcess runs the rendered Cmd once with its env, workdir and limits, setting its Output, ExitCode and Status.
+// the whole process group of the Cmd is killed when its Timeout expires or ctx is cancelled.
+func (c *CmdRunner) runProcess(cmdCtx context.Context, cmd *Cmd) {
+	ctx := cmdCtx
+	if cmd.Timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, cmd.Timeout)
+		defer stop()
+	}
+
+	out := new(outputBuffer)
+	run, err := limitedCommand(*cmd)
 	if err != nil {
-		cmd.Output = string(out)
-		cmd.Status = "error"
+		cmd.Output = "failed to apply resource limits: " + err.Error()
+		cmd.ExitCode = -1
+		cmd.Status = StatusError
+		return
+	}
+	if lf, ok := c.LineCallBacks[cmd.Stream]; ok && cmd.Stream != "" {
+		stdout := &lineWriter{out: out, stream: StreamStdout, cmd: *cmd, fn: lf}
+		stderr := &lineWriter{out: out, stream: StreamStderr, cmd: *cmd, fn: lf}
+		defer stdout.Flush()
+		defer stderr.Flush()
+		run.Stdout = stdout
+		run.Stderr = stderr
 	} else {
-		cmd.Output = string(out)
-		cmd.Status = "success"
+		run.Stdout = out
+		run.Stderr = out
 	}
-	if cmd.CallBack != "none" {
-		err := c.CallBacks[cmd.CallBack](cmd)
-		if err != nil {
-			cmd.Output = "callback failed"
-			cmd.Status = "error"
+	run.Env = cmd.environ()
+	run.Dir = cmd.WorkDir
+	setProcAttr(run)
+	err = run.Start()
+	if err != nil {
+		out.WriteString(err.Error())
+	}
+	if err == nil {
+		done := make(chan error, 1)
+		go func() { done <- run.Wait() }()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			Dprint("killing", cmd.Name, ctx.Err())
+			signalProcs(run, syscall.SIGTERM)
+			select {
+			case err = <-done:
+			case <-time.After(KillGrace):
+				signalProcs(run, syscall.SIGKILL)
+				err = <-done
+			}
 		}
 	}
+	cmd.Output = out.String()
+	cmd.ExitCode = 0
+	if run.ProcessState != nil {
+		cmd.ExitCode = run.ProcessState.ExitCode()
+	} else if err != nil {
+		cmd.ExitCode = -1
+	}
+	var limit string
+	if err != nil {
+		limit = limitHit(*cmd, run.ProcessState, cmd.Output)
+	}
+	switch {
+	case cmdCtx.Err() != nil:
+		cmd.Status = StatusCancelled
+	case ctx.Err() != nil:
+		cmd.Status = StatusTimeout
+		cmd.Output += "\nkilled after timeout " + cmd.Timeout.String()
+	case limit != "":
+		cmd.Status = StatusLimit
+		cmd.Output += "\nstopped by " + limit
+	case !cmd.exitOK(cmd.ExitCode):
+		cmd.Status = StatusError
+		if err == nil {
+			cmd.Output += "\nexit code 0 is not in success_exit_codes"
+		}
+	default:
+		cmd.Status = StatusSuccess
+		if reason := cmd.verify(); reason != "" {
+			cmd.Status = StatusUnverified
+			cmd.Output += "\n" + reason
+		}
+	}
+}
+
+// parseVars renders the cmdline or args templates of a Cmd in place
+func (c *CmdRunner) parseVars(cmd *Cmd) error {
+	return c.renderCmd(cmd)
+}
+
+// startRunner executes a Cmd that was moved to the RunningQ, then schedules whatever it unblocked.
+func (c *CmdRunner) startRunner(ctx context.Context, cmd Cmd) {
+	cmd = c.execute(ctx, cmd)
+	c.mu.Lock()
 	delete(c.RunningQ, cmd.QID)
+	c.cancels[cmd.QID]()
+	delete(c.cancels, cmd.QID)
+	c.mu.Unlock()
+	c.addCompleted(cmd)
+	c.release()
 	c.doNextRunner()
-	wg.Done()
-
 }
 
-func (c *CmdRunner) GetStatus() (Queue, Queue) {
-	return c.RunningQ, c.WaitingQ
+func (c *CmdRunner) addCompleted(cmd Cmd) {
+	c.mu.Lock()
+	c.Completed = append(c.Completed, cmd)
+	c.status[DepKey(c.Name, cmd.Name)] = cmd.Status
+	c.mu.Unlock()
+	if c.OnDone != nil {
+		c.OnDone(cmd)
+	}
+	if cmd.Parent != "" {
+		c.jobDone(cmd)
+	}
+	c.mu.Lock()
+	if c.idle != nil {
+		c.pending--
+		if c.pending == 0 {
+			close(c.idle)
+			c.idle = nil
+		}
+	}
+	c.mu.Unlock()
 }
 
-// GetNextRunner gets the QID of the next runner in the wait queue, handy for "next command" status lines
-func (c *CmdRunner) GetNextRunner() Cmd {
-	var curlow int
-	for runid := range c.WaitingQ {
-		if curlow == 0 {
-			curlow = runid
-		} else {
-			if runid < curlow {
-				curlow = runid
-			}
+// Failed returns the completed Cmds that did not finish successfully
+func (c *CmdRunner) Failed() []Cmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret []Cmd
+	for _, i := range c.Completed {
+		if i.Status != StatusSuccess {
+			ret = append(ret, i)
 		}
 	}
-	return c.WaitingQ[curlow]
+	return ret
 }
 
-func (c *CmdRunner) doNextRunner() {
-	if len(c.RunningQ) >= c.MaxThreads {
-		// Dprint("concurrency still maxed")
-		return
+// GetStatus returns copies of the running and waiting queues
+func (c *CmdRunner) GetStatus() (Queue, Queue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.RunningQ.copy(), c.WaitingQ.copy()
+}
+
+func (q Queue) copy() Queue {
+	r := make(Queue, len(q))
+	for k, v := range q {
+		r[k] = v
 	}
-	if len(c.WaitingQ) < 1 {
-		// Dprint("nothing in wait queue")
-		return
+	return r
+}
+
+// GetNextRunner gets the next runner in the wait queue, handy for "next command" status lines
+func (c *CmdRunner) GetNextRunner() Cmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.WaitingQ[c.nextQIDLocked()]
+}
+
+// nextQIDLocked returns the QID of the next Cmd to start from the wait queue, or 0 if it is empty. c.mu must be held.
+func (c *CmdRunner) nextQIDLocked() int {
+	q := c.queueOrderLocked()
+	if len(q) == 0 {
+		return 0
 	}
+	return q[0]
+}
 
-	n := c.GetNextRunner()
+// queueOrderLocked returns the QIDs of the wait queue, highest Priority first and oldest first within a Priority. c.mu must be held.
+func (c *CmdRunner) queueOrderLocked() []int {
+	q := make([]int, 0, len(c.WaitingQ))
+	for qid := range c.WaitingQ {
+		q = append(q, qid)
+	}
+	sort.Slice(q, func(i, j int) bool {
+		a, b := c.WaitingQ[q[i]], c.WaitingQ[q[j]]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return q[i] < q[j]
+	})
+	return q
+}
 
-	delete(c.WaitingQ, n.QID)
-	nid := newQueueID(&c.RunningQ)
-	c.RunningQ[nid] = n
-	n.QID = nid
-	n.Status = "running"
-	Dprint("starting next:", nid)
-	wg.Add(1)
-	go c.startRunner(n)
+// maxThreads returns MaxThreads, at least 1, or 1 when running sequentially
+func (c *CmdRunner) maxThreads() int {
+	if c.MaxThreads < 1 || c.sequential {
+		return 1
+	}
+	return c.MaxThreads
 }
 
-func (c *CmdRunner) runOrQueue(cmd Cmd) {
-	if len(c.RunningQ) < c.MaxThreads {
-		nid := newQueueID(&c.RunningQ)
-		Dprint("Starting Thread:", nid)
-		c.RunningQ[nid] = cmd
-		cmd.QID = nid
-		cmd.Status = "running"
-		wg.Add(1)
-		go c.startRunner(cmd)
-	} else {
-		nid := newQueueID(&c.WaitingQ)
-		cmd.QID = nid
-		cmd.Status = "queued"
-		c.WaitingQ[nid] = cmd
-		Dprint("Queueing Thread:", nid)
+// weight returns how many threads a Cmd uses, a Cmd heavier than MaxThreads uses them all
+func (c *CmdRunner) weight(cmd Cmd) int {
+	switch {
+	case cmd.Weight < 1:
+		return 1
+	case cmd.Weight > c.maxThreads():
+		return c.maxThreads()
 	}
+	return cmd.Weight
 }
 
-func newQueueID(daQ *Queue) int {
-	var ids []int
-	for key := range *daQ {
-		ids = append(ids, key)
+// doNextRunner moves Cmds from the wait queue to the running queue in queueOrderLocked order, while their weight fits in MaxThreads.
+// a Cmd whose pool is full is passed over for the next one, but a Cmd that is too heavy for the free threads
+// blocks the queue until enough threads are free, so a heavy Cmd is not starved by lighter ones.
+// if the context was cancelled the wait queue is drained instead.
+func (c *CmdRunner) doNextRunner() {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		var cancelled []Cmd
+		for qid, i := range c.WaitingQ {
+			delete(c.WaitingQ, qid)
+			cancelled = append(cancelled, i)
+		}
+		c.mu.Unlock()
+		for _, i := range cancelled {
+			c.cancel(i)
+		}
+		return
+	}
+	if c.paused {
+		c.mu.Unlock()
+		return
 	}
-	if len(ids) == 0 {
-		ids = append(ids, 1)
+	used := 0
+	pools := make(map[string]int)
+	for _, i := range c.RunningQ {
+		used += c.weight(i)
+		pools[i.Pool]++
 	}
-	var total int
-	for j := 1; j < total; j++ {
-		if ids[0] < ids[j] {
-			ids[0] = ids[j]
+	for _, qid := range c.queueOrderLocked() {
+		n := c.WaitingQ[qid]
+		if n.Pool != "" && pools[n.Pool] >= c.Pools[n.Pool] {
+			continue
 		}
-
+		if used+c.weight(n) > c.maxThreads() {
+			break
+		}
+		used += c.weight(n)
+		pools[n.Pool]++
+		delete(c.WaitingQ, qid)
+		n.Status = StatusRunning
+		c.RunningQ[qid] = n
+		ctx, cancel := context.WithCancel(c.ctx)
+		c.cancels[qid] = cancel
+		Dprint("starting next:", qid)
+		go c.startRunner(ctx, n)
 	}
-	return ids[0] + 1
+	c.mu.Unlock()
+}
+
+// enqueue adds a Cmd to the wait queue with a new QID, without starting anything.
+func (c *CmdRunner) enqueue(cmd Cmd) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastQID++
+	cmd.QID = c.lastQID
+	cmd.Status = StatusQueued
+	c.WaitingQ[cmd.QID] = cmd
+	Dprint("Queueing Thread:", cmd.QID)
 }
@@ -0,0 +1,323 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls the queues of a running CmdRunner until cond holds, and fails the test after a few seconds
+func waitFor(t *testing.T, c *CmdRunner, cond func(running, waiting Queue) bool) {
+	t.Helper()
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(5 * time.Millisecond) {
+		if cond(c.GetStatus()) {
+			return
+		}
+	}
+	t.Fatal("timed out waiting for the runner")
+}
+
+// newTestRunner returns a CmdRunner with the done callback, which does nothing
+func newTestRunner() *CmdRunner {
+	c := NewCmdRunner()
+	c.CallBacks["done"] = func(Cmd) error { return nil }
+	return c
+}
+
+// qidOf returns the QID of the named Cmd in q, or 0
+func qidOf(q Queue, name string) int {
+	for qid, i := range q {
+		if i.Name == name {
+			return qid
+		}
+	}
+	return 0
+}
+
+func TestRunFIFO(t *testing.T) {
+	c := newTestRunner()
+	c.MaxThreads = 1
+	var order []Cmd
+	c.OnDone = func(cmd Cmd) { order = append(order, cmd) }
+	var r Runners
+	for i := 0; i < 20; i++ {
+		r = append(r, Cmd{Name: fmt.Sprint(i), CmdLine: "true", CallBack: "done"})
+	}
+	if err := c.Run(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != len(r) {
+		t.Fatalf("%d Cmds completed, want %d", len(order), len(r))
+	}
+	for n, cmd := range order {
+		if cmd.Name != fmt.Sprint(n) || cmd.QID != n+1 || cmd.Status != StatusSuccess {
+			t.Errorf("completed #%d is %s QID %d %s, want %d QID %d success", n, cmd.Name, cmd.QID, cmd.Status, n, n+1)
+		}
+	}
+}
+
+func TestRunPriority(t *testing.T) {
+	c := newTestRunner()
+	c.MaxThreads = 1
+	var order []string
+	c.OnDone = func(cmd Cmd) { order = append(order, cmd.Name) }
+	r := Runners{
+		{Name: "low", CmdLine: "true", CallBack: "done"},
+		{Name: "high", CmdLine: "true", CallBack: "done", Priority: 10},
+		{Name: "low2", CmdLine: "true", CallBack: "done"},
+		{Name: "mid", CmdLine: "true", CallBack: "done", Priority: 5},
+	}
+	if err := c.Run(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"high", "mid", "low", "low2"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("order %v, want %v", order, want)
+	}
+}
+
+func TestRunMaxThreads(t *testing.T) {
+	for _, max := range []int{1, 3} {
+		c := newTestRunner()
+		c.MaxThreads = max
+		var r Runners
+		for i := 0; i < 12; i++ {
+			r = append(r, Cmd{Name: fmt.Sprint(i), CmdLine: "sleep 0.05", CallBack: "done"})
+		}
+		r = append(r, Cmd{Name: "heavy", CmdLine: "sleep 0.05", CallBack: "done", Weight: 2})
+		stop, done := make(chan struct{}), make(chan struct{})
+		peak := 0
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				running, _ := c.GetStatus()
+				used := 0
+				for _, i := range running {
+					// a Cmd heavier than MaxThreads uses them all
+					switch {
+					case i.Weight < 1:
+						used++
+					case i.Weight > max:
+						used += max
+					default:
+						used += i.Weight
+					}
+				}
+				if used > max {
+					t.Errorf("MaxThreads %d: %d threads used by %d Cmds", max, used, len(running))
+				}
+				if used > peak {
+					peak = used
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		if err := c.Run(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		close(stop)
+		<-done
+		if len(c.Completed) != len(r) {
+			t.Errorf("MaxThreads %d: %d Cmds completed, want %d", max, len(c.Completed), len(r))
+		}
+		if peak != max {
+			t.Errorf("MaxThreads %d: at most %d threads were used", max, peak)
+		}
+	}
+}
+
+func TestRunConcurrentRunners(t *testing.T) {
+	var wg sync.WaitGroup
+	for n := 0; n < 2; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			c := newTestRunner()
+			c.Name = fmt.Sprint("stage", n)
+			c.MaxThreads = 3
+			var mu sync.Mutex
+			pos := make(map[string]int)
+			c.CallBacks["record"] = func(cmd Cmd) error {
+				mu.Lock()
+				pos[cmd.Name] = len(pos)
+				mu.Unlock()
+				return nil
+			}
+			var r Runners
+			for i := 0; i < 20; i++ {
+				r = append(r, Cmd{Name: fmt.Sprint("c", i), CmdLine: "echo {{.Name}}", CallBack: "record"})
+			}
+			r = append(r, Cmd{Name: "last", CmdLine: "echo {{.Name}}", CallBack: "record", DependsOn: []string{"c0", "c19"}})
+			c.VarMap["Name"] = func(cmd *Cmd) string { return cmd.Name }
+			if err := c.Run(context.Background(), r); err != nil {
+				t.Error(err)
+				return
+			}
+			if len(c.Completed) != len(r) || len(c.Failed()) > 0 {
+				t.Errorf("runner %d: %d completed, %d failed", n, len(c.Completed), len(c.Failed()))
+			}
+			if pos["last"] < pos["c0"] || pos["last"] < pos["c19"] {
+				t.Errorf("runner %d: last ran before its dependencies", n)
+			}
+			for _, cmd := range c.Completed {
+				if cmd.Output != cmd.Name+"\n" {
+					t.Errorf("runner %d: %s output %q", n, cmd.Name, cmd.Output)
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func TestCancelQID(t *testing.T) {
+	c := newTestRunner()
+	c.MaxThreads = 1
+	r := Runners{
+		{Name: "running", CmdLine: "sleep 10", CallBack: "done"},
+		{Name: "queued", CmdLine: "true", CallBack: "done"},
+		{Name: "blocked", CmdLine: "true", CallBack: "done", DependsOn: []string{"running"}},
+	}
+	errc := make(chan error)
+	go func() { errc <- c.Run(context.Background(), r) }()
+	waitFor(t, c, func(running, waiting Queue) bool {
+		return qidOf(running, "running") != 0 && qidOf(waiting, "queued") != 0
+	})
+	running, waiting := c.GetStatus()
+	if err := c.Cancel(qidOf(waiting, "queued")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cancel(qidOf(running, "running")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cancel(qidOf(running, "running")); err == nil {
+		t.Error("cancelling a completed Cmd did not fail")
+	}
+	want := map[string]string{"running": StatusCancelled, "queued": StatusCancelled, "blocked": StatusSkipped}
+	for _, cmd := range c.Completed {
+		if cmd.Status != want[cmd.Name] {
+			t.Errorf("%s is %s, want %s", cmd.Name, cmd.Status, want[cmd.Name])
+		}
+		delete(want, cmd.Name)
+	}
+	if len(want) > 0 {
+		t.Errorf("not completed: %v", want)
+	}
+}
+
+func TestCancelContext(t *testing.T) {
+	c := newTestRunner()
+	c.MaxThreads = 1
+	r := Runners{
+		{Name: "running", CmdLine: "sleep 10", CallBack: "done"},
+		{Name: "queued", CmdLine: "true", CallBack: "done"},
+		{Name: "blocked", CmdLine: "true", CallBack: "done", DependsOn: []string{"queued"}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- c.Run(ctx, r) }()
+	waitFor(t, c, func(running, waiting Queue) bool {
+		return qidOf(running, "running") != 0 && qidOf(waiting, "queued") != 0
+	})
+	start := time.Now()
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run returned %v, want %v", err, context.Canceled)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the running Cmd was not killed")
+	}
+	if len(c.Completed) != len(r) {
+		t.Fatalf("%d Cmds completed, want %d", len(c.Completed), len(r))
+	}
+	for _, cmd := range c.Completed {
+		if cmd.Status != StatusCancelled {
+			t.Errorf("%s is %s, want %s", cmd.Name, cmd.Status, StatusCancelled)
+		}
+	}
+}
+
+func TestCancelPaused(t *testing.T) {
+	c := newTestRunner()
+	c.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- c.Run(ctx, Runners{{Name: "queued", CmdLine: "true", CallBack: "done"}}) }()
+	waitFor(t, c, func(running, waiting Queue) bool { return len(waiting) == 1 })
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run returned %v, want %v", err, context.Canceled)
+	}
+	if len(c.Completed) != 1 || c.Completed[0].Status != StatusCancelled {
+		t.Errorf("completed %v, want queued cancelled", c.Completed)
+	}
+}
+
+func TestControlsDuringRun(t *testing.T) {
+	c := newTestRunner()
+	c.MaxThreads = 4
+	var r Runners
+	for i := 0; i < 40; i++ {
+		r = append(r, Cmd{Name: fmt.Sprint(i), CmdLine: "true", CallBack: "done"})
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for n := 0; n < 3; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c.Pause()
+				c.GetStatus()
+				c.GetNextRunner()
+				c.Paused()
+				c.Resume()
+			}
+		}()
+	}
+	// the controls are already running when the later runs start
+	var err error
+	for n := 0; n < 3 && err == nil; n++ {
+		err = c.Run(context.Background(), r)
+	}
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Completed) != 3*len(r) || len(c.Failed()) > 0 {
+		t.Errorf("%d completed, %d failed, want %d successes", len(c.Completed), len(c.Failed()), 3*len(r))
+	}
+}
+
+func TestRunAfterRunWait(t *testing.T) {
+	c := newTestRunner()
+	c.MaxThreads = 4
+	r := Runners{{Name: "a", CmdLine: "true", CallBack: "done"}, {Name: "b", CmdLine: "true", CallBack: "done"}}
+	if err := c.RunWait(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	// the runner is parallel again once RunWait returned
+	r = Runners{{Name: "c", CmdLine: "sleep 0.3", CallBack: "done"}, {Name: "d", CmdLine: "sleep 0.3", CallBack: "done"}}
+	errc := make(chan error)
+	go func() { errc <- c.Run(context.Background(), r) }()
+	waitFor(t, c, func(running, waiting Queue) bool { return len(running) == 2 })
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
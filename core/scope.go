@@ -12,8 +12,25 @@ import (
 type IPs []string
 
 type Scope struct {
-	Ranges   IPs
-	Excludes IPs
+	Ranges   IPs `yaml:"ranges"`
+	Excludes IPs `yaml:"excludes"`
+}
+
+// Validate makes sure every range and exclude in the scope can be parsed.
+func (s Scope) Validate() error {
+	all := append(append(IPs{}, s.Ranges...), s.Excludes...)
+	for _, i := range all {
+		if strings.Contains(i, "/") {
+			if _, err := netaddr.NewIPNetwork(i); err != nil {
+				return errors.New("invalid scope range " + i + ": " + ErrStr(err))
+			}
+			continue
+		}
+		if _, err := expandNmapRange(i); err != nil {
+			return errors.New("invalid scope range " + i)
+		}
+	}
+	return nil
 }
 
 // GetInScopeIPs returns a slice of all IPs that meet the scope criteria.
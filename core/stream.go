@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// streams passed to a LineFunc
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// outputBuffer collects the combined output of a Cmd, it is written to from the stdout and stderr goroutines.
+type outputBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *outputBuffer) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *outputBuffer) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+func (o *outputBuffer) WriteString(s string) {
+	o.Write([]byte(s))
+}
+
+// lineWriter copies one output stream of a Cmd into the combined output, and calls fn for every complete line.
+type lineWriter struct {
+	out     *outputBuffer
+	stream  string
+	cmd     Cmd
+	fn      LineFunc
+	partial []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.out.Write(p)
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.fn(l.cmd, l.stream, strings.TrimSuffix(string(l.partial[:i]), "\r"))
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes the last line to fn if the output did not end with a newline
+func (l *lineWriter) Flush() {
+	if len(l.partial) > 0 {
+		l.fn(l.cmd, l.stream, string(l.partial))
+		l.partial = nil
+	}
+}
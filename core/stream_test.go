@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string
+	}{
+		{"lines", []string{"a\nb\n"}, []string{"a", "b"}},
+		{"split writes", []string{"a", "b\nc", "\n"}, []string{"ab", "c"}},
+		{"final partial line", []string{"a\nb"}, []string{"a", "b"}},
+		{"crlf", []string{"a\r\nb\r\n"}, []string{"a", "b"}},
+		{"empty lines", []string{"\n\na\n"}, []string{"", "", "a"}},
+		{"nothing", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			out := new(outputBuffer)
+			l := &lineWriter{out: out, stream: StreamStdout, cmd: Cmd{Name: "t"}, fn: func(c Cmd, stream, line string) {
+				if c.Name != "t" || stream != StreamStdout {
+					t.Errorf("line of %s on %s", c.Name, stream)
+				}
+				got = append(got, line)
+			}}
+			for _, w := range tt.writes {
+				l.Write([]byte(w))
+			}
+			l.Flush()
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+			if out.String() != strings.Join(tt.writes, "") {
+				t.Errorf("output %q, want the writes as is", out.String())
+			}
+		})
+	}
+}
+
+func TestStream(t *testing.T) {
+	c := newTestRunner()
+	var mu sync.Mutex
+	lines := make(map[string][]string)
+	c.LineCallBacks["lines"] = func(cmd Cmd, stream, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines[cmd.Name+" "+stream] = append(lines[cmd.Name+" "+stream], line)
+	}
+	r := Runners{
+		{Name: "streamed", CmdLine: `echo a; echo e1 >&2; echo b; echo e2 >&2; printf tail`, Stream: "lines", CallBack: "done"},
+		{Name: "quiet", CmdLine: "echo q", CallBack: "done"},
+	}
+	if err := c.Run(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"streamed stdout": "a b tail",
+		"streamed stderr": "e1 e2",
+	}
+	if len(lines) != len(want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+	for k, w := range want {
+		if got := strings.Join(lines[k], " "); got != w {
+			t.Errorf("%s lines %q, want %q", k, got, w)
+		}
+	}
+	for _, cmd := range c.Completed {
+		if cmd.Name == "streamed" && (!strings.Contains(cmd.Output, "a\n") || !strings.Contains(cmd.Output, "e2\n") || !strings.Contains(cmd.Output, "tail")) {
+			t.Errorf("output %q does not have both streams", cmd.Output)
+		}
+	}
+	if err := c.Run(context.Background(), Runners{{Name: "bad", CmdLine: "true", Stream: "nope", CallBack: "done"}}); err == nil {
+		t.Error("a Cmd with an unknown stream was run")
+	}
+}
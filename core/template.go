@@ -0,0 +1,396 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// helperFuncs are the functions available to every cmdline template, on top of the VarMap entries.
+var helperFuncs = template.FuncMap{
+	"join":    tmplJoin,
+	"quote":   tmplQuote,
+	"default": tmplDefault,
+	"env":     os.Getenv,
+	"file":    tmplFile,
+}
+
+// shellWord is a value that was already quoted for bash, printing it in a template is safe.
+type shellWord string
+
+// quotedFuncs replace the helpers that return outside data when rendering for bash, so their values are quoted too.
+var quotedFuncs = template.FuncMap{
+	"env": func(name string) shellWord {
+		return shellWord(ShellQuote(os.Getenv(name)))
+	},
+	"file": func(path string) (shellWord, error) {
+		s, err := tmplFile(path)
+		return shellWord(ShellQuote(s)), err
+	},
+	"default": tmplQuotedDefault,
+}
+
+// tmplQuote quotes a value for bash, values that are already quoted are returned as is.
+func tmplQuote(v interface{}) shellWord {
+	if w, ok := v.(shellWord); ok {
+		return w
+	}
+	return shellWord(ShellQuote(fmt.Sprint(v)))
+}
+
+// tmplJoin joins strings and string slices with sep: {{ join "," .A .B }}
+func tmplJoin(sep string, items ...interface{}) string {
+	var s []string
+	for _, i := range items {
+		switch v := i.(type) {
+		case []string:
+			s = append(s, v...)
+		case []shellWord:
+			for _, w := range v {
+				s = append(s, string(w))
+			}
+		case string:
+			s = append(s, v)
+		default:
+			s = append(s, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(s, sep)
+}
+
+// tmplDefault returns val, or def when val is empty: {{ default "large" (env "PORTS") }}
+func tmplDefault(def string, val interface{}) string {
+	if val == nil {
+		return def
+	}
+	s := fmt.Sprint(val)
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// tmplQuotedDefault is default when rendering for bash, a quoted empty value falls back to def, which is quoted too.
+func tmplQuotedDefault(def string, val interface{}) shellWord {
+	w, ok := val.(shellWord)
+	switch {
+	case ok && w != "''":
+		return w
+	case ok:
+		return tmplQuote(def)
+	}
+	return tmplQuote(tmplDefault(def, val))
+}
+
+// tmplFile returns the content of a file without the trailing newline: {{ file "/etc/webrecon/apikey" }}
+func tmplFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// ShellQuote wraps s in single quotes so bash treats it as a single literal word.
+func ShellQuote(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `'\''`) + `'`
+}
+
+// parseCmdTemplate parses a cmdline, VarMap entries can be used as fields {{ .OutFile }} or functions {{ OutFile }}.
+// vars are called when the template is executed, nil funcs are only good for parsing.
+func parseCmdTemplate(name, text string, funcs template.FuncMap, quoted bool) (*template.Template, error) {
+	t := template.New(name).Option("missingkey=error").Funcs(helperFuncs)
+	if quoted {
+		t = t.Funcs(quotedFuncs)
+	}
+	return t.Funcs(funcs).Parse(text)
+}
+
+// templateFields returns the names of the top level fields used by a template, {{ .OutFile }} returns OutFile.
+func templateFields(t *template.Template) []string {
+	var fields []string
+	seen := make(map[string]bool)
+	walkTemplate(t, func(n parse.Node) {
+		if v, ok := n.(*parse.FieldNode); ok && !seen[v.Ident[0]] {
+			seen[v.Ident[0]] = true
+			fields = append(fields, v.Ident[0])
+		}
+	})
+	return fields
+}
+
+// templateParams returns the names of the params used by a template, {{ .Params.mode }} returns mode.
+func templateParams(t *template.Template) []string {
+	var params []string
+	walkTemplate(t, func(n parse.Node) {
+		if v, ok := n.(*parse.FieldNode); ok && len(v.Ident) > 1 && v.Ident[0] == "Params" {
+			params = append(params, v.Ident[1])
+		}
+	})
+	return params
+}
+
+// templateTools returns the names of the registry tools used by a template, {{ tool "amass" }} returns amass.
+func templateTools(t *template.Template) []string {
+	var tools []string
+	walkTemplate(t, func(n parse.Node) {
+		v, ok := n.(*parse.CommandNode)
+		if !ok || len(v.Args) != 2 {
+			return
+		}
+		if id, ok := v.Args[0].(*parse.IdentifierNode); ok && id.Ident == "tool" {
+			if s, ok := v.Args[1].(*parse.StringNode); ok {
+				tools = append(tools, s.Text)
+			}
+		}
+	})
+	return tools
+}
+
+// walkTemplate calls fn with every node of a template
+func walkTemplate(t *template.Template, fn func(n parse.Node)) {
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		fn(n)
+		switch v := n.(type) {
+		case *parse.ListNode:
+			if v == nil {
+				return
+			}
+			for _, i := range v.Nodes {
+				walk(i)
+			}
+		case *parse.ActionNode:
+			walk(v.Pipe)
+		case *parse.PipeNode:
+			if v == nil {
+				return
+			}
+			for _, i := range v.Cmds {
+				walk(i)
+			}
+		case *parse.CommandNode:
+			for _, i := range v.Args {
+				walk(i)
+			}
+		case *parse.ChainNode:
+			walk(v.Node)
+		case *parse.IfNode:
+			walk(v.Pipe)
+			walk(v.List)
+			walk(v.ElseList)
+		case *parse.RangeNode:
+			walk(v.Pipe)
+			walk(v.List)
+			walk(v.ElseList)
+		case *parse.WithNode:
+			walk(v.Pipe)
+			walk(v.List)
+			walk(v.ElseList)
+		case *parse.TemplateNode:
+			walk(v.Pipe)
+		}
+	}
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			walk(tmpl.Tree.Root)
+		}
+	}
+}
+
+// varFuncs returns the VarMap, the foreach Item, the template Params and the registry tools as template functions, with placeholder functions when cmd is nil.
+// each var is called at most once per Cmd, values holds the results so {{ .OutFile }} used twice, or in both
+// the env and the cmdline, gives the same file.
+// when quoted is set the values are shell quoted, so a scraped value can not inject commands into bash.
+func (c *CmdRunner) varFuncs(cmd *Cmd, quoted bool, values map[string]string) template.FuncMap {
+	funcs := make(template.FuncMap)
+	for k, vf := range c.VarMap {
+		k, vf := k, vf
+		funcs[k] = func() interface{} {
+			if cmd == nil {
+				return ""
+			}
+			v, ok := values[k]
+			if !ok {
+				v = vf(cmd)
+				values[k] = v
+			}
+			if quoted {
+				return shellWord(ShellQuote(v))
+			}
+			return v
+		}
+	}
+	funcs["tool"] = func(name string) (interface{}, error) {
+		path, ok := c.Tools[name]
+		if !ok {
+			return "", errors.New("tool " + name + " is not in the registry")
+		}
+		if quoted {
+			return shellWord(ShellQuote(path)), nil
+		}
+		return path, nil
+	}
+	funcs["Params"] = func() interface{} {
+		if cmd == nil || !quoted {
+			return cmd.paramValues()
+		}
+		params := make(map[string]interface{}, len(cmd.Params))
+		for k, v := range cmd.Params {
+			switch v := v.(type) {
+			case string:
+				params[k] = shellWord(ShellQuote(v))
+			case []string:
+				words := make([]shellWord, len(v))
+				for n, w := range v {
+					words[n] = shellWord(ShellQuote(w))
+				}
+				params[k] = words
+			default:
+				params[k] = v // ints and bools are safe for bash
+			}
+		}
+		return params
+	}
+	funcs["Item"] = func() interface{} {
+		if cmd == nil {
+			return ""
+		}
+		if quoted {
+			return shellWord(ShellQuote(cmd.Item))
+		}
+		return cmd.Item
+	}
+	return funcs
+}
+
+// cmdTemplates returns the templates of a Cmd, the cmdline or one per entry of args.
+func cmdTemplates(cmd Cmd) []string {
+	if len(cmd.Args) > 0 {
+		return cmd.Args
+	}
+	return []string{cmd.CmdLine}
+}
+
+// validateTemplate parses the cmdline or args of a Cmd, and checks every field they use is in the VarMap
+// and every tool is in the registry.
+func (c *CmdRunner) validateTemplate(cmd Cmd) error {
+	if len(cmd.Args) > 0 && cmd.CmdLine != "" {
+		return errors.New("use either cmdline or args, not both")
+	}
+	if len(cmd.Args) == 0 && cmd.CmdLine == "" {
+		return errors.New("cmdline or args is required")
+	}
+	funcs := c.varFuncs(nil, false, nil)
+	texts := make(map[string]string)
+	for n, text := range cmdTemplates(cmd) {
+		texts[fmt.Sprintf("%s[%d]", cmd.Name, n)] = text
+	}
+	for k, text := range cmd.Env {
+		texts[cmd.Name+" env "+k] = text
+	}
+	texts[cmd.Name+" workdir"] = cmd.WorkDir
+	for name, text := range texts {
+		t, err := parseCmdTemplate(name, text, funcs, false)
+		if err != nil {
+			return err
+		}
+		for _, f := range templateFields(t) {
+			if f == "Item" && (cmd.Foreach != "" || cmd.Item != "") {
+				continue
+			}
+			if f == "Params" && cmd.Params != nil {
+				continue
+			}
+			if _, ok := c.VarMap[f]; !ok {
+				return errors.New(f + " is not in CmdRunner.VarMap")
+			}
+		}
+		for _, p := range templateParams(t) {
+			if _, ok := cmd.Params[p]; !ok {
+				return errors.New("param " + p + " is not defined by tool " + cmd.Use)
+			}
+		}
+		for _, tool := range templateTools(t) {
+			if _, ok := c.Tools[tool]; !ok {
+				return errors.New("tool " + tool + " is not in the registry")
+			}
+		}
+	}
+	return nil
+}
+
+// renderTemplate executes a template of a Cmd with funcs from varFuncs, calling only the VarFuncs it uses.
+func renderTemplate(name, text string, funcs template.FuncMap, quoted bool) (string, error) {
+	t, err := parseCmdTemplate(name, text, funcs, quoted)
+	if err != nil {
+		return "", err
+	}
+	data := make(map[string]interface{})
+	for _, f := range templateFields(t) {
+		vf, ok := funcs[f].(func() interface{})
+		if !ok {
+			return "", errors.New(f + " is not in CmdRunner.VarMap")
+		}
+		data[f] = vf()
+	}
+	var out bytes.Buffer
+	err = t.Execute(&out, data)
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// renderCmd renders the cmdline of a Cmd for bash with every variable quoted,
+// or each of its args as is when the Cmd is executed without a shell.
+// env values and the workdir are never seen by bash, they are rendered as is.
+func (c *CmdRunner) renderCmd(cmd *Cmd) error {
+	values := make(map[string]string)
+	for k, v := range cmd.Overrides {
+		values[k] = v
+	}
+	funcs := c.varFuncs(cmd, false, values)
+	if len(cmd.Env) > 0 {
+		env := make(map[string]string, len(cmd.Env))
+		for k, text := range cmd.Env {
+			v, err := renderTemplate(cmd.Name+" env "+k, text, funcs, false)
+			if err != nil {
+				return err
+			}
+			env[k] = v
+		}
+		cmd.Env = env
+	}
+	dir, err := renderTemplate(cmd.Name+" workdir", cmd.WorkDir, funcs, false)
+	if err != nil {
+		return err
+	}
+	cmd.WorkDir = dir
+	cmd.vars = values
+	if len(cmd.Args) == 0 {
+		line, err := renderTemplate(cmd.Name, cmd.CmdLine, c.varFuncs(cmd, true, values), true)
+		if err != nil {
+			return err
+		}
+		cmd.CmdLine = line
+		return nil
+	}
+	args := make([]string, len(cmd.Args))
+	quoted := make([]string, len(cmd.Args))
+	for n, text := range cmd.Args {
+		a, err := renderTemplate(fmt.Sprintf("%s[%d]", cmd.Name, n), text, funcs, false)
+		if err != nil {
+			return err
+		}
+		args[n] = a
+		quoted[n] = ShellQuote(a)
+	}
+	cmd.Args = args
+	cmd.CmdLine = strings.Join(quoted, " ") // only for display, args are executed directly
+	return nil
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTemplateRunner returns a CmdRunner with the Dom and Evil vars and the nmap tool
+func newTemplateRunner() *CmdRunner {
+	c := newTestRunner()
+	c.VarMap["Dom"] = func(*Cmd) string { return "a.com" }
+	c.VarMap["Evil"] = func(*Cmd) string { return "x'; rm -rf /" }
+	c.Tools = map[string]string{"nmap": "/usr/bin/nmap"}
+	return c
+}
+
+func TestRenderCmd(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "key")
+	if err := os.WriteFile(file, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WR_TEST_SET", "a b")
+	t.Setenv("WR_TEST_EMPTY", "")
+	tests := []struct {
+		name string
+		cmd  Cmd
+		want string
+		args []string
+	}{
+		{"field", Cmd{CmdLine: "echo {{ .Dom }}"}, "echo 'a.com'", nil},
+		{"field without spaces", Cmd{CmdLine: "echo {{.Dom}}"}, "echo 'a.com'", nil},
+		{"func", Cmd{CmdLine: "echo {{ Dom }} {{Dom}}"}, "echo 'a.com' 'a.com'", nil},
+		{"injection", Cmd{CmdLine: "echo {{.Evil}}"}, `echo 'x'\''; rm -rf /'`, nil},
+		{"literal text", Cmd{CmdLine: "echo $HOME | tr a b"}, "echo $HOME | tr a b", nil},
+		{"if", Cmd{CmdLine: `{{ if .Dom }}echo {{ .Dom }}{{ end }}`}, "echo 'a.com'", nil},
+		{"quote", Cmd{CmdLine: `echo {{ quote "a b" }}`}, "echo 'a b'", nil},
+		{"quote quoted", Cmd{CmdLine: `echo {{ quote .Dom }}`}, "echo 'a.com'", nil},
+		{"join", Cmd{CmdLine: `echo {{ join "," .Dom .Evil }}`}, `echo 'a.com','x'\''; rm -rf /'`, nil},
+		{"join params", Cmd{CmdLine: `echo {{ join "," .Params.sev }}`, Params: map[string]interface{}{"sev": []string{"high", "critical"}}}, "echo 'high','critical'", nil},
+		{"params", Cmd{CmdLine: `echo {{ .Params.mode }} {{ .Params.n }}`, Params: map[string]interface{}{"mode": "a b", "n": 3}}, "echo 'a b' 3", nil},
+		{"env", Cmd{CmdLine: `echo {{ env "WR_TEST_SET" }}`}, "echo 'a b'", nil},
+		{"env empty", Cmd{CmdLine: `echo {{ env "WR_TEST_EMPTY" }}`}, "echo ''", nil},
+		{"default set", Cmd{CmdLine: `echo {{ default "c d" (env "WR_TEST_SET") }}`}, "echo 'a b'", nil},
+		{"default empty", Cmd{CmdLine: `echo {{ default "c d" (env "WR_TEST_EMPTY") }}`}, "echo 'c d'", nil},
+		{"default literal", Cmd{CmdLine: `echo {{ default "it's" "" }}`}, `echo 'it'\''s'`, nil},
+		{"file", Cmd{CmdLine: `echo {{ file "` + file + `" }}`}, "echo 's3cret'", nil},
+		{"tool", Cmd{CmdLine: `{{ tool "nmap" }} -sV`}, "'/usr/bin/nmap' -sV", nil},
+		{"item", Cmd{CmdLine: "echo {{ .Item }}", Item: "b c"}, "echo 'b c'", nil},
+		{"args", Cmd{Args: []string{"-d", "{{.Dom}}", `{{ default "x" (env "WR_TEST_EMPTY") }}`, "{{ .Evil }}"}}, `'-d' 'a.com' 'x' 'x'\''; rm -rf /'`, []string{"-d", "a.com", "x", "x'; rm -rf /"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTemplateRunner()
+			cmd := tt.cmd
+			cmd.Name = "t"
+			if err := c.validateTemplate(cmd); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.renderCmd(&cmd); err != nil {
+				t.Fatal(err)
+			}
+			if cmd.CmdLine != tt.want {
+				t.Errorf("cmdline %s, want %s", cmd.CmdLine, tt.want)
+			}
+			if strings.Join(cmd.Args, "|") != strings.Join(tt.args, "|") {
+				t.Errorf("args %q, want %q", cmd.Args, tt.args)
+			}
+		})
+	}
+}
+
+func TestRenderCmdUnquoted(t *testing.T) {
+	t.Setenv("WR_TEST_EMPTY", "")
+	c := newTemplateRunner()
+	calls := 0
+	c.VarMap["OutFile"] = func(*Cmd) string {
+		calls++
+		return "/tmp/out file"
+	}
+	cmd := Cmd{
+		Name:    "t",
+		CmdLine: "cat {{.OutFile}}",
+		Env:     map[string]string{"OUT": "{{ .OutFile }}", "SIZE": `{{ default "large" (env "WR_TEST_EMPTY") }}`},
+		WorkDir: "/tmp/{{.Dom}}",
+	}
+	if err := c.renderCmd(&cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.CmdLine != "cat '/tmp/out file'" || cmd.Env["OUT"] != "/tmp/out file" || cmd.Env["SIZE"] != "large" || cmd.WorkDir != "/tmp/a.com" {
+		t.Errorf("rendered %q, env %q, workdir %q", cmd.CmdLine, cmd.Env, cmd.WorkDir)
+	}
+	if calls != 1 {
+		t.Errorf("OutFile was called %d times, want once", calls)
+	}
+}
+
+func TestRenderCmdErrors(t *testing.T) {
+	c := newTemplateRunner()
+	for _, text := range []string{`{{ file "/nonexistent/key" }}`, `{{ tool "amass" }}`} {
+		cmd := Cmd{Name: "t", CmdLine: text}
+		if err := c.renderCmd(&cmd); err == nil {
+			t.Errorf("%s rendered %s", text, cmd.CmdLine)
+		}
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	tests := []struct {
+		cmd Cmd
+		err string
+	}{
+		{Cmd{Name: "unclosed", CmdLine: "echo {{ .Dom"}, "unclosed: template: unclosed[0]:1: unclosed action"},
+		{Cmd{Name: "unknown-var", CmdLine: "echo {{ .Nope }}"}, "unknown-var: Nope is not in CmdRunner.VarMap"},
+		{Cmd{Name: "unknown-func", CmdLine: "echo {{ nope }}"}, `unknown-func: template: unknown-func[0]:1: function "nope" not defined`},
+		{Cmd{Name: "bad-arg", Args: []string{"-d", "{{ .Dom }"}}, "bad-arg: template: bad-arg[1]:1: unexpected \"}\" in operand"},
+		{Cmd{Name: "bad-env", CmdLine: "true", Env: map[string]string{"X": "{{ .Nope }}"}}, "bad-env: Nope is not in CmdRunner.VarMap"},
+		{Cmd{Name: "both", CmdLine: "true", Args: []string{"true"}}, "both: use either cmdline or args, not both"},
+		{Cmd{Name: "none"}, "none: cmdline or args is required"},
+		{Cmd{Name: "tool", CmdLine: `{{ tool "amass" }}`}, "tool: tool amass is not in the registry"},
+		{Cmd{Name: "param", CmdLine: "echo {{ .Params.x }}", Use: "t", Params: map[string]interface{}{}}, "param: param x is not defined by tool t"},
+		{Cmd{Name: "item", CmdLine: "echo {{ .Item }}"}, "item: Item is not in CmdRunner.VarMap"},
+	}
+	c := newTemplateRunner()
+	r := Runners{{Name: "ok", CmdLine: "echo {{.Dom}} {{ tool \"nmap\" }}"}}
+	var want []string
+	for _, tt := range tests {
+		r = append(r, tt.cmd)
+		want = append(want, tt.err)
+	}
+	for n := range r {
+		r[n].CallBack = "done"
+	}
+	err := c.Run(context.Background(), r)
+	if err == nil {
+		t.Fatal("the invalid Cmds were run")
+	}
+	got := strings.Split(err.Error(), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("%d errors, want %d:\n%v", len(got), len(want), err)
+	}
+	for n := range want {
+		if got[n] != want[n] {
+			t.Errorf("error %s, want %s", got[n], want[n])
+		}
+	}
+	if len(c.Completed) > 0 {
+		t.Errorf("%d Cmds ran", len(c.Completed))
+	}
+}
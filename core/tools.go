@@ -0,0 +1,295 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// param types of a ToolTemplate
+var paramTypes = []string{"string", "int", "bool", "duration", "list"}
+
+// ToolTemplate is a named, parameterised command in the tools library, a stage entry instantiates it with
+// use: and sets its params with with:, every other key set on the entry replaces the one of the template, eg:
+//
+//	tools:
+//	  nuclei:
+//	    params: {targets: {required: true}, severity: {type: list, default: [high, critical]}}
+//	    cmdline: '{{ tool "nuclei" }} -l {{ .Params.targets }} -s {{ join "," .Params.severity }} -o {{ .OutFile }}'
+//	stages:
+//	  - runners:
+//	      - {name: nuclei-crit, use: nuclei, with: {targets: /tmp/t, severity: [critical]}, timeout: 1h}
+//
+// keys are replaced as a whole, an entry with env: {A: "1"} drops the env of the template, and a key set to a zero
+// value like retries: 0 or env: {} clears the template value.
+// templates read params as {{ .Params.name }}, strings are shell quoted in cmdlines like vars, lists are used with join.
+type ToolTemplate struct {
+	Params map[string]Param `yaml:"params"`
+	Cmd    `yaml:",inline"`
+}
+
+// Param is a parameter of a ToolTemplate
+type Param struct {
+	Type     string      `yaml:"type"`     // Type is string (default), int, bool, duration or list
+	Default  interface{} `yaml:"default"`  // Default is used when the stage entry does not set the param
+	Required bool        `yaml:"required"` // Required params must be set by every stage entry
+}
+
+// validateTools checks the params of every ToolTemplate and their defaults
+func validateTools(tools map[string]ToolTemplate) error {
+	for name, t := range tools {
+		if t.Use != "" {
+			return errors.New("tool " + name + " can not use another tool")
+		}
+		for pn, p := range t.Params {
+			if p.Type != "" && !SliceContains(paramTypes, p.Type) {
+				return fmt.Errorf("tool %s: param %s has unknown type %s, use string, int, bool, duration or list", name, pn, p.Type)
+			}
+			if p.Default != nil {
+				if _, err := p.value(p.Default); err != nil {
+					return fmt.Errorf("tool %s: default of param %s: %v", name, pn, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// UseTool returns the Cmd of a stage entry that uses a ToolTemplate, entries without use are returned as is.
+// the entry must be decoded from yaml, as part of Runners or with DecodeCmd, so the keys it sets are known.
+func (c *Config) UseTool(entry Cmd) (Cmd, error) {
+	if entry.Use == "" {
+		if len(entry.With) > 0 {
+			return entry, errors.New(entry.Name + ": with requires use")
+		}
+		return entry, nil
+	}
+	t, ok := c.Recon.Tools[entry.Use]
+	if !ok {
+		return entry, errors.New(entry.Name + ": tool " + entry.Use + " is not in the tools library")
+	}
+	if entry.entry == nil {
+		return entry, errors.New(entry.Name + ": use requires a command decoded from yaml")
+	}
+	cmd, err := mergeEntry(t.Cmd, entry.entry)
+	if err != nil {
+		return entry, errors.New(entry.Name + ": use " + entry.Use + ": " + err.Error())
+	}
+	if cmd.Name == "" {
+		cmd.Name = entry.Use
+	}
+	params := make(map[string]interface{})
+	for k, v := range entry.With {
+		p, ok := t.Params[k]
+		if !ok {
+			return cmd, errors.New(cmd.Name + ": tool " + entry.Use + " has no param " + k)
+		}
+		val, err := p.value(v)
+		if err != nil {
+			return cmd, fmt.Errorf("%s: param %s: %v", cmd.Name, k, err)
+		}
+		params[k] = val
+	}
+	for k, p := range t.Params {
+		if _, ok := params[k]; ok {
+			continue
+		}
+		if p.Required {
+			return cmd, errors.New(cmd.Name + ": tool " + entry.Use + " requires param " + k)
+		}
+		params[k], _ = p.value(p.Default)
+	}
+	cmd.Params = params
+	cmd.With = nil
+	return cmd, nil
+}
+
+// mergeEntry returns the template Cmd with the keys of a yaml entry replacing its own
+func mergeEntry(tmpl Cmd, entry map[string]interface{}) (Cmd, error) {
+	var cmd Cmd
+	b, err := yaml.Marshal(tmpl)
+	if err != nil {
+		return cmd, err
+	}
+	merged := make(map[string]interface{})
+	err = yaml.Unmarshal(b, &merged)
+	if err != nil {
+		return cmd, err
+	}
+	for k, v := range entry {
+		merged[k] = v
+	}
+	b, err = yaml.Marshal(merged)
+	if err != nil {
+		return cmd, err
+	}
+	err = yaml.UnmarshalStrict(b, &cmd)
+	return cmd, err
+}
+
+// UnmarshalYAML decodes a list of Cmds, and keeps the keys set on the entries with use for UseTool
+func (r *Runners) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var cmds []Cmd
+	err := unmarshal(&cmds)
+	if err != nil {
+		return err
+	}
+	var entries []map[string]interface{}
+	err = unmarshal(&entries)
+	if err != nil {
+		return err
+	}
+	for n := range cmds {
+		if cmds[n].Use != "" {
+			cmds[n].entry = entries[n]
+		}
+	}
+	*r = cmds
+	return nil
+}
+
+// DecodeCmd decodes a single Cmd from yaml, like an entry of Runners, eg: for ctl add
+func DecodeCmd(b []byte) (Cmd, error) {
+	var cmd Cmd
+	err := yaml.UnmarshalStrict(b, &cmd)
+	if err != nil || cmd.Use == "" {
+		return cmd, err
+	}
+	err = yaml.Unmarshal(b, &cmd.entry)
+	return cmd, err
+}
+
+// value converts a param value from yaml to its Type, nil gives the zero value of the type
+func (p Param) value(v interface{}) (interface{}, error) {
+	switch p.Type {
+	case "int":
+		switch n := v.(type) {
+		case nil:
+			return 0, nil
+		case int:
+			return n, nil
+		case string:
+			return strconv.Atoi(n)
+		}
+	case "bool":
+		switch b := v.(type) {
+		case nil:
+			return false, nil
+		case bool:
+			return b, nil
+		case string:
+			return strconv.ParseBool(b)
+		}
+	case "duration":
+		switch d := v.(type) {
+		case nil:
+			return "", nil
+		case string:
+			_, err := time.ParseDuration(d)
+			return d, err
+		}
+	case "list":
+		switch l := v.(type) {
+		case nil:
+			return []string{}, nil
+		case []interface{}:
+			var s []string
+			for _, i := range l {
+				s = append(s, fmt.Sprint(i))
+			}
+			return s, nil
+		default:
+			return []string{fmt.Sprint(l)}, nil
+		}
+	default:
+		switch s := v.(type) {
+		case nil:
+			return "", nil
+		case []interface{}, map[interface{}]interface{}:
+			return nil, fmt.Errorf("%v is not a string", s)
+		default:
+			return fmt.Sprint(s), nil
+		}
+	}
+	return nil, fmt.Errorf("%v is not a %s", v, p.Type)
+}
+
+// useTools replaces the stage entries that use a ToolTemplate with their Cmd
+func (c *Config) useTools() error {
+	lists := []Runners{c.Recon.TargetID, c.Recon.Flyover}
+	for _, st := range c.Recon.Stages {
+		lists = append(lists, st.Runners)
+	}
+	for _, r := range lists {
+		for n := range r {
+			cmd, err := c.UseTool(r[n])
+			if err != nil {
+				return err
+			}
+			r[n] = cmd
+		}
+	}
+	return nil
+}
+
+// include merges the tools, registry and pools of the included config files, paths are relative to the config.
+// entries of the config itself win over included ones with the same name.
+func (c *Config) include(configPath string) error {
+	for _, inc := range c.Include {
+		path := inc
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(configPath), path)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return errors.New("include " + inc + ": " + err.Error())
+		}
+		var lib Config
+		err = yaml.UnmarshalStrict(b, &lib)
+		if err != nil {
+			return errors.New("include " + inc + ": " + err.Error())
+		}
+		if len(lib.Include) > 0 || len(lib.Recon.Stages) > 0 || len(lib.Recon.TargetID) > 0 || len(lib.Recon.Flyover) > 0 {
+			return errors.New("include " + inc + ": only recon.tools, recon.registry and recon.pools can be included")
+		}
+		if c.Recon.Tools == nil {
+			c.Recon.Tools = make(map[string]ToolTemplate)
+		}
+		for name, t := range lib.Recon.Tools {
+			if _, ok := c.Recon.Tools[name]; !ok {
+				c.Recon.Tools[name] = t
+			}
+		}
+		if c.Recon.Pools == nil {
+			c.Recon.Pools = make(map[string]int)
+		}
+		for name, max := range lib.Recon.Pools {
+			if _, ok := c.Recon.Pools[name]; !ok {
+				c.Recon.Pools[name] = max
+			}
+		}
+		for _, t := range lib.Recon.Registry {
+			known := false
+			for _, r := range c.Recon.Registry {
+				known = known || r.Name == t.Name
+			}
+			if !known {
+				c.Recon.Registry = append(c.Recon.Registry, t)
+			}
+		}
+	}
+	return nil
+}
+
+// paramValues returns the Params of a Cmd, an empty map for a nil Cmd so templates can be checked without one
+func (cmd *Cmd) paramValues() map[string]interface{} {
+	if cmd == nil {
+		return map[string]interface{}{}
+	}
+	return cmd.Params
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const toolsConfig = `
+recon:
+  tools:
+    nuclei:
+      params:
+        targets: {required: true}
+        severity: {type: list, default: [high, critical]}
+        rate: {type: int, default: 150}
+      cmdline: 'nuclei -l {{ .Params.targets }} -s {{ join "," .Params.severity }} -rl {{ .Params.rate }}'
+      timeout: 2h
+      retries: 3
+      expect_output_file: true
+      env: {A: "1"}
+      callback: findings
+  stages:
+    - name: s1
+      runners:
+        - {use: nuclei, with: {targets: /tmp/t}}
+        - {name: crit, use: nuclei, with: {targets: /tmp/t, severity: [critical], rate: "50"}, timeout: 1h}
+        - {name: zero, use: nuclei, with: {targets: /tmp/t}, retries: 0, expect_output_file: false, env: {}, callback: ""}
+        - {name: plain, cmdline: "true", retries: 2}
+`
+
+func TestUseTool(t *testing.T) {
+	var c Config
+	if err := yaml.UnmarshalStrict([]byte(toolsConfig), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateTools(c.Recon.Tools); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.useTools(); err != nil {
+		t.Fatal(err)
+	}
+	r := c.Recon.Stages[0].Runners
+	tests := []struct {
+		cmd      Cmd
+		name     string
+		timeout  time.Duration
+		retries  int
+		expect   bool
+		env      int
+		callback string
+		params   string
+	}{
+		{r[0], "nuclei", 2 * time.Hour, 3, true, 1, "findings", "map[rate:150 severity:[high critical] targets:/tmp/t]"},
+		{r[1], "crit", time.Hour, 3, true, 1, "findings", "map[rate:50 severity:[critical] targets:/tmp/t]"},
+		{r[2], "zero", 2 * time.Hour, 0, false, 0, "", "map[rate:150 severity:[high critical] targets:/tmp/t]"},
+		{r[3], "plain", 0, 2, false, 0, "", "map[]"},
+	}
+	for _, tt := range tests {
+		cmd := tt.cmd
+		if cmd.Name != tt.name || cmd.Timeout != tt.timeout || cmd.Retries != tt.retries || cmd.ExpectOutputFile != tt.expect ||
+			len(cmd.Env) != tt.env || cmd.CallBack != tt.callback || fmt.Sprint(cmd.Params) != tt.params || cmd.With != nil {
+			t.Errorf("%s: got %s timeout %v retries %d expect %v env %v callback %q params %s with %v", tt.name,
+				cmd.Name, cmd.Timeout, cmd.Retries, cmd.ExpectOutputFile, cmd.Env, cmd.CallBack, fmt.Sprint(cmd.Params), cmd.With)
+		}
+		if tt.name != "plain" && cmd.CmdLine != c.Recon.Tools["nuclei"].CmdLine {
+			t.Errorf("%s: cmdline %s", tt.name, cmd.CmdLine)
+		}
+	}
+	if c.Recon.Tools["nuclei"].Retries != 3 || len(c.Recon.Tools["nuclei"].Env) != 1 {
+		t.Error("the template was changed by the entries using it")
+	}
+}
+
+func TestUseToolErrors(t *testing.T) {
+	var c Config
+	if err := yaml.UnmarshalStrict([]byte(toolsConfig), &c); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		entry string
+		err   string
+	}{
+		{"{name: a, use: nope}", "a: tool nope is not in the tools library"},
+		{"{name: a, use: nuclei}", "a: tool nuclei requires param targets"},
+		{"{name: a, use: nuclei, with: {targets: /t, x: 1}}", "a: tool nuclei has no param x"},
+		{"{name: a, use: nuclei, with: {targets: /t, rate: fast}}", "a: param rate: "},
+		{"{name: a, cmdline: 'true', with: {targets: /t}}", "a: with requires use"},
+		{"{name: a, use: nuclei, with: {targets: /t}, retries: many}", "cannot unmarshal"},
+		{"{name: a, use: nuclei, with: {targets: /t}, nope: 1}", "field nope not found"},
+	}
+	for _, tt := range tests {
+		cmd, err := DecodeCmd([]byte(tt.entry))
+		if err == nil {
+			_, err = c.UseTool(cmd)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %s", tt.entry, err, tt.err)
+		}
+	}
+	// an entry made in Go has no yaml keys to merge
+	if _, err := c.UseTool(Cmd{Name: "a", Use: "nuclei"}); err == nil {
+		t.Error("a Cmd that was not decoded from yaml used a tool")
+	}
+	cmd, err := DecodeCmd([]byte("{name: added, use: nuclei, with: {targets: /t}, retries: 0}"))
+	if err == nil {
+		cmd, err = c.UseTool(cmd)
+	}
+	if err != nil || cmd.Retries != 0 || cmd.Timeout != 2*time.Hour {
+		t.Errorf("DecodeCmd: %v retries %d timeout %v", err, cmd.Retries, cmd.Timeout)
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+)
+
+// generators of config vars
+const (
+	GenTargets  = "targets"  // a file with the targets of the project, one per line
+	GenRootDoms = "rootdoms" // the root domains as csv, or a file
+	GenOutput   = "output"   // a new output file of the Cmd, like OutFile
+)
+
+// Var is a variable defined in the config, used in templates like the VarFuncs of the project, eg:
+//
+//	vars:
+//	  Wordlist: {value: /usr/share/wordlists/dns.txt}
+//	  ShodanKey: {env: SHODAN_API_KEY, required: true}
+//	  Client: {project: name}
+//	  WebHosts: {generate: targets, type: domain, tag: "port:443"}
+//	  JSONOut: {generate: output, ext: .json}
+//
+// a var has a static value, or one of env, project and generate.
+type Var struct {
+	Value    string `yaml:"value"`    // Value is a static value
+	Env      string `yaml:"env"`      // Env names an environment variable
+	Default  string `yaml:"default"`  // Default is used when the Env variable is empty
+	Required bool   `yaml:"required"` // Required stops the run before it starts when the Env variable is empty
+	Project  string `yaml:"project"`  // Project names a field of the project, eg: name or data_dir
+	Generate string `yaml:"generate"` // Generate names a generator: targets, rootdoms or output
+	Type     string `yaml:"type"`     // Type limits targets to domain, ip or url
+	Tag      string `yaml:"tag"`      // Tag limits targets to the ones with a tag, eg: port:443 or severity:high
+	Format   string `yaml:"format"`   // Format of targets and rootdoms: file or csv
+	Ext      string `yaml:"ext"`      // Ext is the extension of an output file, eg: .json
+}
+
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateVars checks every var has one source and only the options of its generator
+func validateVars(vars map[string]Var) error {
+	for name, v := range vars {
+		if !varName.MatchString(name) {
+			return errors.New("var " + name + ": names are letters, digits and _, eg: ShodanKey")
+		}
+		if _, ok := helperFuncs[name]; ok || name == "Item" || name == "Params" || name == "tool" {
+			return errors.New("var " + name + ": the name is used by templates, use another one")
+		}
+		n := 0
+		for _, s := range []string{v.Env, v.Project, v.Generate} {
+			if s != "" {
+				n++
+			}
+		}
+		if n > 1 || (n == 1 && v.Value != "") {
+			return errors.New("var " + name + ": use only one of value, env, project and generate")
+		}
+		if v.Env == "" && (v.Default != "" || v.Required) {
+			return errors.New("var " + name + ": default and required are only for env")
+		}
+		switch v.Generate {
+		case "":
+		case GenTargets:
+			if v.Type != "" && v.Type != "domain" && v.Type != "ip" && v.Type != "url" {
+				return errors.New("var " + name + ": unknown type " + v.Type + ", use domain, ip or url")
+			}
+		case GenRootDoms:
+		case GenOutput:
+			if v.Format != "" {
+				return errors.New("var " + name + ": format is only for targets and rootdoms")
+			}
+		default:
+			return errors.New("var " + name + ": unknown generator " + v.Generate + ", use targets, rootdoms or output")
+		}
+		if v.Generate != GenTargets && (v.Type != "" || v.Tag != "") {
+			return errors.New("var " + name + ": type and tag are only for generate: targets")
+		}
+		if v.Generate != GenOutput && v.Ext != "" {
+			return errors.New("var " + name + ": ext is only for generate: output")
+		}
+		if v.Format != "" && v.Format != "file" && v.Format != "csv" {
+			return errors.New("var " + name + ": unknown format " + v.Format + ", use file or csv")
+		}
+	}
+	return nil
+}
+
+// MissingEnv returns the required env vars that are not set, as "var (ENV)"
+func MissingEnv(vars map[string]Var, getenv func(string) string) []string {
+	var missing []string
+	for name, v := range vars {
+		if v.Required && getenv(v.Env) == "" {
+			missing = append(missing, name+" ("+v.Env+")")
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
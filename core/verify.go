@@ -0,0 +1,96 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// validateChecks checks the success criteria of a Cmd
+func (cmd Cmd) validateChecks() error {
+	for _, code := range cmd.SuccessExitCodes {
+		if code < 0 || code > 255 {
+			return fmt.Errorf("success_exit_codes: %d is not an exit code, use 0 to 255", code)
+		}
+	}
+	if cmd.MinOutputLines < 0 {
+		return errors.New("min_output_lines can not be negative")
+	}
+	if cmd.FailOnOutput != "" {
+		if _, err := regexp.Compile(cmd.FailOnOutput); err != nil {
+			return errors.New("invalid fail_on_output regex: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// exitOK reports if an exit code is a success, 0 unless the Cmd has SuccessExitCodes
+func (cmd Cmd) exitOK(code int) bool {
+	if len(cmd.SuccessExitCodes) == 0 {
+		return code == 0
+	}
+	for _, c := range cmd.SuccessExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the output of a Cmd that exited with a success code, and returns why it is not a success,
+// so a tool that failed silently is flagged instead of passing an empty result to its callback.
+func (cmd Cmd) verify() string {
+	if cmd.FailOnOutput != "" {
+		if m := regexp.MustCompile(cmd.FailOnOutput).FindString(cmd.Output); m != "" {
+			return fmt.Sprintf("output matches fail_on_output: %q", m)
+		}
+	}
+	if !cmd.ExpectOutputFile && cmd.MinOutputLines == 0 {
+		return ""
+	}
+	if cmd.ExpectOutputFile {
+		if cmd.OutputFile == "" {
+			return "expect_output_file is set but the command has no output file, use {{ .OutFile }}"
+		}
+		st, err := os.Stat(cmd.OutputFile)
+		if err != nil {
+			return "the output file was not written"
+		}
+		if st.Size() == 0 {
+			return "the output file is empty"
+		}
+	}
+	if cmd.MinOutputLines > 0 {
+		n := countLines(cmd)
+		if n < cmd.MinOutputLines {
+			return fmt.Sprintf("%d output line(s), min_output_lines is %d", n, cmd.MinOutputLines)
+		}
+	}
+	return ""
+}
+
+// countLines returns the number of non empty lines in the output file of a Cmd, or in its output when it has none
+func countLines(cmd Cmd) int {
+	var r io.Reader = strings.NewReader(cmd.Output)
+	if cmd.OutputFile != "" {
+		f, err := os.Open(cmd.OutputFile)
+		if err != nil {
+			return 0
+		}
+		defer f.Close()
+		r = f
+	}
+	n := 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if strings.TrimSpace(sc.Text()) != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSuccessCriteria(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    Cmd
+		status string
+		reason string // the end of the output
+	}{
+		{"exit 0", Cmd{CmdLine: "exit 0"}, StatusSuccess, ""},
+		{"exit 1", Cmd{CmdLine: "exit 1"}, StatusError, ""},
+		{"success exit codes", Cmd{CmdLine: "exit 1", SuccessExitCodes: []int{0, 1}}, StatusSuccess, ""},
+		{"exit 0 not a success code", Cmd{CmdLine: "exit 0", SuccessExitCodes: []int{1}}, StatusError, "exit code 0 is not in success_exit_codes"},
+		{"output file written", Cmd{CmdLine: "echo a > {{ .OutFile }}", ExpectOutputFile: true}, StatusSuccess, ""},
+		{"output file missing", Cmd{CmdLine: "true #{{ .OutFile }}", ExpectOutputFile: true}, StatusUnverified, "the output file was not written"},
+		{"output file empty", Cmd{CmdLine: "touch {{ .OutFile }}", ExpectOutputFile: true}, StatusUnverified, "the output file is empty"},
+		{"no output file", Cmd{CmdLine: "true", ExpectOutputFile: true}, StatusUnverified, "expect_output_file is set but the command has no output file, use {{ .OutFile }}"},
+		{"failed with an empty output file", Cmd{CmdLine: "touch {{ .OutFile }}; exit 2", ExpectOutputFile: true}, StatusError, ""},
+		{"min lines of the output file", Cmd{CmdLine: `printf 'a\n\n  \nb\n' > {{ .OutFile }}`, MinOutputLines: 2}, StatusSuccess, ""},
+		{"too few lines", Cmd{CmdLine: `printf 'a\n\n  \nb\n' > {{ .OutFile }}`, MinOutputLines: 3}, StatusUnverified, "2 output line(s), min_output_lines is 3"},
+		{"min lines of the output", Cmd{CmdLine: "echo a; echo b", MinOutputLines: 2}, StatusSuccess, ""},
+		{"fail on output", Cmd{CmdLine: "echo Error: 401 unauthorized", FailOnOutput: `Error: \d+`}, StatusUnverified, `output matches fail_on_output: "Error: 401"`},
+		{"fail on output not matched", Cmd{CmdLine: "echo ok", FailOnOutput: `Error: \d+`}, StatusSuccess, ""},
+		{"fail on output of a failure", Cmd{CmdLine: "echo Error: 1; exit 1", FailOnOutput: `Error: \d+`}, StatusError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := newTestRunner()
+			c.VarMap["OutFile"] = func(cmd *Cmd) string {
+				cmd.OutputFile = filepath.Join(dir, "out")
+				return cmd.OutputFile
+			}
+			cmd := tt.cmd
+			cmd.Name = "t"
+			cmd.CallBack = "done"
+			if err := c.Run(context.Background(), Runners{cmd}); err != nil {
+				t.Fatal(err)
+			}
+			got := c.Completed[0]
+			if got.Status != tt.status || (tt.reason != "" && !strings.HasSuffix(got.Output, "\n"+tt.reason)) {
+				t.Errorf("status %s output %q, want %s %q", got.Status, got.Output, tt.status, tt.reason)
+			}
+		})
+	}
+}
+
+func TestValidateChecks(t *testing.T) {
+	tests := []struct {
+		cmd Cmd
+		err string
+	}{
+		{Cmd{SuccessExitCodes: []int{0, 1, 255}, MinOutputLines: 1, FailOnOutput: "(?i)error"}, ""},
+		{Cmd{SuccessExitCodes: []int{256}}, "success_exit_codes: 256 is not an exit code, use 0 to 255"},
+		{Cmd{SuccessExitCodes: []int{-1}}, "success_exit_codes: -1 is not an exit code, use 0 to 255"},
+		{Cmd{MinOutputLines: -1}, "min_output_lines can not be negative"},
+		{Cmd{FailOnOutput: "("}, "invalid fail_on_output regex: error parsing regexp: missing closing ): `(`"},
+	}
+	for _, tt := range tests {
+		err := tt.cmd.validateChecks()
+		if got := fmt.Sprint(err); (tt.err == "" && err != nil) || (tt.err != "" && got != tt.err) {
+			t.Errorf("%+v: error %v, want %q", tt.cmd, err, tt.err)
+		}
+	}
+}
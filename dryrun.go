@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"webrecon/core"
+)
+
+// dryRunRecon prints the commands every stage would run, rendered with the current project, without running them.
+// VarFuncs that write files write them to a temporary sandbox that is removed afterwards, the sandbox path is shown as DataDir.
+// nothing is resolved and no state is saved, so variables built from discovered hosts are empty.
+// every problem found is printed, and returned together: missing vars and callbacks, bad templates, programs that are not installed
+// and registry tools that are missing or too old.
+func (p *Project) dryRunRecon(out io.Writer) error {
+	sandbox, err := os.MkdirTemp("", "webrecon-dry-run-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(sandbox)
+	dataDir := p.DataDir
+	p.DataDir = sandbox + "/"
+	defer func() { p.DataDir = dataDir }()
+	p.state = projectState{Cmds: make(map[string]cmdState)}
+
+	fmt.Fprintln(out, "dry run of project", p.Name+", nothing is executed")
+	fmt.Fprintln(out, "scope:       ", len(p.Scope.GetInScopeIPs()), "IPs in", strings.Join(p.Scope.Ranges, ","))
+	if len(p.Scope.Excludes) > 0 {
+		fmt.Fprintln(out, "excluded:    ", strings.Join(p.Scope.Excludes, ","))
+	}
+	fmt.Fprintln(out, "root domains:", len(p.RootDoms), strings.Join(p.RootDoms, ","))
+
+	var problems []string
+	if missing := core.MissingEnv(c.Recon.Vars, os.Getenv); len(missing) > 0 {
+		fmt.Fprintln(out, "! required environment variables are not set:", strings.Join(missing, ", "))
+		problems = append(problems, "required environment variables are not set: "+strings.Join(missing, ", "))
+	}
+	p.tools, err = core.CheckTools(c.Recon.Registry)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(c.Recon.Registry) > 0 {
+		fmt.Fprintln(out, "\ntools")
+		for _, t := range c.Recon.Registry {
+			fmt.Fprintf(out, "  %-16s %s %s\n", t.Name, p.tools[t.Name].Path, p.tools[t.Name].Version)
+		}
+		if err != nil {
+			for _, line := range strings.Split(err.Error(), "\n")[1:] {
+				fmt.Fprintln(out, "  !", line)
+			}
+		}
+	}
+	prior := make(core.StatusMap)
+	for _, st := range c.GetStages() {
+		mode := st.Mode
+		if mode == "" {
+			mode = core.ModeParallel
+		}
+		fmt.Fprintf(out, "\nstage %s (%s)\n", st.Name, mode)
+		vars, cbs, lcbs, err := p.stageBindings(st)
+		if err != nil {
+			fmt.Fprintln(out, "  !", err)
+			problems = append(problems, err.Error())
+		}
+		r := core.NewCmdRunner()
+		r.VarMap = vars
+		r.CallBacks = cbs
+		r.LineCallBacks = lcbs
+		r.Pools = c.Recon.Pools
+		r.Items = p.Items
+		r.Tools = p.toolPaths()
+		r.Name = st.Name
+		r.DryRun = true
+		for k, v := range prior {
+			r.Prior[k] = v
+		}
+		if st.Mode == core.ModeSequential {
+			err = r.RunWait(context.Background(), st.Runners)
+		} else {
+			err = r.Run(context.Background(), st.Runners)
+		}
+		if err != nil {
+			problems = append(problems, "stage "+st.Name+": "+err.Error())
+		}
+		for _, i := range r.Completed {
+			prior[core.DepKey(st.Name, i.Name)] = i.Status
+			fmt.Fprintf(out, "  [%s] %s\n", i.Status, i.Name)
+			line := i.CmdLine
+			if line == "" {
+				line = strings.Join(i.Args, " ") // args that failed to render
+			}
+			fmt.Fprintln(out, "    "+strings.ReplaceAll(line, p.DataDir, dataDir))
+			if i.Status == core.StatusPlanned {
+				if i.Output != "" {
+					fmt.Fprintln(out, "    #", i.Output)
+				}
+				continue
+			}
+			for _, line := range strings.Split(i.Output, "\n") {
+				fmt.Fprintln(out, "    !", line)
+			}
+		}
+		if err != nil && len(r.Completed) == 0 {
+			fmt.Fprintln(out, "  !", err)
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("dry run found problems:\n" + strings.Join(problems, "\n"))
+	}
+	fmt.Fprintln(out, "\nno problems found")
+	return nil
+}
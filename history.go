@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"webrecon/core"
+)
+
+// newRunID returns an id for a run started at t, runs started in the same second get a -2, -3, ... suffix
+// so they do not overwrite each other in the run history.
+func (p *Project) newRunID(t time.Time) string {
+	base := t.Format("20060102-150405")
+	id := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(p.runPath(id)); os.IsNotExist(err) {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+func (p *Project) runsDir() string {
+	return p.DataDir + "runs/"
+}
+
+func (p *Project) runPath(id string) string {
+	return p.runsDir() + id + ".json"
+}
+
+// LoadRuns returns the snapshot of every recorded run, oldest first.
+func (p *Project) LoadRuns() ([]projectState, error) {
+	files, err := filepath.Glob(p.runsDir() + "*.json")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var runs []projectState
+	for _, f := range files {
+		r, err := loadRunFile(f)
+		if err != nil {
+			core.Eprint("skipping run", f, err)
+			continue
+		}
+		runs = append(runs, r)
+	}
+	// by start time, "-2" suffixes do not sort after the run they follow
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Started.Before(runs[j].Started)
+	})
+	return runs, nil
+}
+
+// LoadRun returns the snapshot of a run, id can also be "latest" or "previous".
+func (p *Project) LoadRun(id string) (projectState, error) {
+	if id == "latest" || id == "previous" {
+		runs, err := p.LoadRuns()
+		if err != nil {
+			return projectState{}, err
+		}
+		n := len(runs) - 1
+		if id == "previous" {
+			n--
+		}
+		if n < 0 {
+			return projectState{}, errors.New("not enough recorded runs for " + id)
+		}
+		return runs[n], nil
+	}
+	r, err := loadRunFile(p.runPath(id))
+	if os.IsNotExist(err) {
+		return r, errors.New("no run with id " + id)
+	}
+	return r, err
+}
+
+func loadRunFile(path string) (projectState, error) {
+	var r projectState
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(b, &r)
+	return r, err
+}
+
+// ipChange is a hostname that resolved to different IPs in two runs
+type ipChange struct {
+	Name string   `json:"name"`
+	From []string `json:"from"`
+	To   []string `json:"to"`
+}
+
+// runDiff lists the assets that changed between two runs
+type runDiff struct {
+	From           string     `json:"from"`
+	To             string     `json:"to"`
+	AddedDomains   []string   `json:"added_domains"`
+	RemovedDomains []string   `json:"removed_domains"`
+	ChangedIPs     []ipChange `json:"changed_ips"`
+	NewTargets     []string   `json:"new_targets"` // NewTargets are the in scope hosts that were not targets in the first run
+}
+
+// diffRuns compares the DNSMap and targets of run a with run b
+func diffRuns(a, b projectState) runDiff {
+	d := runDiff{From: a.RunID, To: b.RunID}
+	for dom, ips := range b.DNSMap {
+		old, ok := a.DNSMap[dom]
+		if !ok {
+			d.AddedDomains = append(d.AddedDomains, dom)
+			continue
+		}
+		from := sortedUnique(old)
+		to := sortedUnique(ips)
+		if strings.Join(from, ",") != strings.Join(to, ",") {
+			d.ChangedIPs = append(d.ChangedIPs, ipChange{Name: dom, From: from, To: to})
+		}
+	}
+	for dom := range a.DNSMap {
+		if _, ok := b.DNSMap[dom]; !ok {
+			d.RemovedDomains = append(d.RemovedDomains, dom)
+		}
+	}
+	for _, t := range b.Targets {
+		if !core.SliceContains(a.Targets, t) {
+			d.NewTargets = append(d.NewTargets, t)
+		}
+	}
+	sort.Strings(d.AddedDomains)
+	sort.Strings(d.RemovedDomains)
+	sort.Strings(d.NewTargets)
+	sort.Slice(d.ChangedIPs, func(i, j int) bool { return d.ChangedIPs[i].Name < d.ChangedIPs[j].Name })
+	return d
+}
+
+func sortedUnique(s []string) []string {
+	r := core.UniqueSlice(s)
+	sort.Strings(r)
+	return r
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewRunID(t *testing.T) {
+	p := &Project{DataDir: t.TempDir() + "/", mu: new(sync.Mutex)}
+	if err := os.MkdirAll(p.runsDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// runs started in the same second get a suffix, the first free one is used
+	for _, want := range []string{"20240102-030405", "20240102-030405-2", "20240102-030405-3"} {
+		id := p.newRunID(at)
+		if id != want {
+			t.Errorf("run id %s, want %s", id, want)
+		}
+		if err := os.WriteFile(p.runPath(id), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id := p.newRunID(at.Add(time.Second)); id != "20240102-030406" {
+		t.Errorf("run id %s, want 20240102-030406", id)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	p := &Project{DataDir: t.TempDir() + "/", mu: new(sync.Mutex)}
+	if _, err := p.LoadRun("latest"); err == nil || err.Error() != "not enough recorded runs for latest" {
+		t.Errorf("latest without runs: %v", err)
+	}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	runs := []projectState{
+		{RunID: "20240102-030405", Started: at, Complete: true,
+			DNSMap:  DNStoIPMap{"a.com": {"10.0.0.1"}, "old.a.com": {"10.0.0.9"}, "www.a.com": {"10.0.0.2", "10.0.0.3"}},
+			Targets: []string{"10.0.0.1", "a.com"}},
+		// started later in the same second, its -2 suffix sorts before the run it follows
+		{RunID: "20240102-030405-2", Started: at.Add(500 * time.Millisecond),
+			DNSMap:  DNStoIPMap{"a.com": {"10.0.0.1"}, "new.a.com": {"10.0.0.7"}, "www.a.com": {"10.0.0.3", "10.0.0.4", "10.0.0.3"}},
+			Targets: []string{"10.0.0.1", "a.com", "new.a.com", "10.0.0.7"}},
+	}
+	if err := os.MkdirAll(p.runsDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range runs {
+		b, _ := json.Marshal(r)
+		if err := os.WriteFile(p.runPath(r.RunID), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(p.runsDir()+"broken.json", []byte("{"), 0644)
+
+	loaded, err := p.LoadRuns()
+	if err != nil || len(loaded) != 2 || loaded[0].RunID != runs[0].RunID || loaded[1].RunID != runs[1].RunID {
+		t.Fatalf("runs %v, error %v", loaded, err)
+	}
+	for id, want := range map[string]string{"latest": runs[1].RunID, "previous": runs[0].RunID, runs[0].RunID: runs[0].RunID} {
+		if r, err := p.LoadRun(id); err != nil || r.RunID != want {
+			t.Errorf("run %s is %s, error %v, want %s", id, r.RunID, err, want)
+		}
+	}
+	if _, err := p.LoadRun("nope"); err == nil || err.Error() != "no run with id nope" {
+		t.Errorf("unknown run: %v", err)
+	}
+
+	var out bytes.Buffer
+	writeRuns(&out, loaded)
+	want := `RUN                STARTED                    STATUS        HOSTNAMES    TARGETS
+20240102-030405    2024-01-02T03:04:05Z       complete              3          2
+20240102-030405-2  2024-01-02T03:04:05Z       interrupted           3          4
+`
+	if out.String() != want {
+		t.Errorf("runs:\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := writeDiff(&out, "txt", loaded[0], loaded[1]); err != nil {
+		t.Fatal(err)
+	}
+	want = `run 20240102-030405 -> 20240102-030405-2
+
+added domains (1):
+  + new.a.com 10.0.0.7
+
+removed domains (1):
+  - old.a.com 10.0.0.9
+
+changed resolutions (1):
+  ~ www.a.com 10.0.0.2 10.0.0.3 -> 10.0.0.3 10.0.0.4
+
+new in scope hosts (2):
+  + 10.0.0.7
+  + new.a.com
+`
+	if out.String() != want {
+		t.Errorf("diff:\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := writeDiff(&out, "json", loaded[0], loaded[1]); err != nil {
+		t.Fatal(err)
+	}
+	var d runDiff
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(d); got != fmt.Sprint(diffRuns(loaded[0], loaded[1])) {
+		t.Errorf("json diff %s", got)
+	}
+	if err := writeDiff(&out, "xml", loaded[0], loaded[1]); err == nil || err.Error() != `unknown diff format "xml"` {
+		t.Errorf("xml format error %v", err)
+	}
+	// a run compared with itself has no changes
+	if d := diffRuns(loaded[1], loaded[1]); d.AddedDomains != nil || d.RemovedDomains != nil || d.ChangedIPs != nil || d.NewTargets != nil {
+		t.Errorf("diff with itself %+v", d)
+	}
+}
@@ -1,53 +1,234 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
 	"webrecon/core"
 )
 
 var c core.Config
 
+// exit codes returned by the cli
+const (
+	exitOK    = 0 // everything ran successfully
+	exitError = 1 // the command, or one of the recon commands failed
+	exitUsage = 2 // bad subcommand or flags
+
+	exitInterrupted = 130 // the run was stopped with Ctrl-C or SIGTERM
+)
+
+const usage = `usage: webrecon <command> [flags]
+
+commands:
+  init     create the project data directory, and the project file if -project is set
+  run      run recon against the project
+  status   show the project configuration and results
+  scope    list the in scope IPs for the project
+  export   export the discovered targets
+  runs     list the recorded runs of the project
+  diff     compare the assets discovered by two runs
+  journal  print or export the journal of every command executed
+  ctl      pause, resume, cancel, reprioritize or add commands of a running project
+
+run "webrecon <command> -h" for the flags of a command
+`
+
+// projectFlags holds the flags shared by every subcommand
+type projectFlags struct {
+	ConfigPath  string
+	ProjectPath string
+	Name        string
+	DataDir     string
+	Threads     int
+	Ranges      string
+	Excludes    string
+	RootDoms    string
+	set         map[string]bool // flags that were explicitly set on the command line
+}
+
+type command struct {
+	desc string
+	run  func(f *flag.FlagSet, pf *projectFlags, args []string) int
+}
+
+var commands = map[string]command{
+	"init":    {"create the project data directory, and the project file if -project is set", cmdInit},
+	"run":     {"run recon against the project", cmdRun},
+	"status":  {"show the project configuration and results", cmdStatus},
+	"scope":   {"list the in scope IPs for the project", cmdScope},
+	"export":  {"export the discovered targets", cmdExport},
+	"runs":    {"list the recorded runs of the project", cmdRuns},
+	"journal": {"print or export the journal of every command executed", cmdJournal},
+	"ctl": {"control a running project: webrecon ctl [flags] <action> [args]\n" +
+		"actions: status, pause, resume, cancel <qid>, priority <qid> <priority>, add <command yaml>\n" +
+		"eg: webrecon ctl -project acme.yaml add '{name: whois, cmdline: \"whois acme.com\", callback: none}'", cmdCtl},
+	"diff": {"compare the assets discovered by two runs: webrecon diff [flags] <runA> <runB>\nrun ids can also be \"latest\" or \"previous\"", cmdDiff},
+}
+
 func main() {
+	os.Exit(runCli(os.Args[1:]))
+}
+
+func runCli(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Print(usage)
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
 
-	// var c core.Config
-	err := c.Init("./config.yaml")
+	f := flag.NewFlagSet("webrecon "+args[0], flag.ContinueOnError)
+	var pf projectFlags
+	f.StringVar(&pf.ConfigPath, "config", "./config.yaml", "path to the config file")
+	f.StringVar(&pf.ProjectPath, "project", "", "path to a project file, other project flags override its values")
+	f.StringVar(&pf.Name, "name", "", "project name")
+	f.StringVar(&pf.DataDir, "data", "", "project data directory (default <general.data_dir>/<name>)")
+	f.IntVar(&pf.Threads, "threads", 5, "max number of concurrent commands")
+	f.StringVar(&pf.Ranges, "scope", "", "comma separated in scope ranges (192.168.56.*,10.0.0.0/24)")
+	f.StringVar(&pf.Excludes, "exclude", "", "comma separated excluded ranges")
+	f.StringVar(&pf.RootDoms, "domains", "", "comma separated root domains")
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "usage: webrecon %s [flags]\n\n%s\n\nflags:\n", args[0], cmd.desc)
+		f.PrintDefaults()
+	}
+	pf.set = make(map[string]bool)
+	return cmd.run(f, &pf, args[1:])
+}
+
+// parseFlags parses the subcommand flags and expects exactly nargs positional arguments,
+// returning an exit code if the caller should stop
+func parseFlags(f *flag.FlagSet, pf *projectFlags, args []string, nargs int) (int, bool) {
+	if err := f.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if nargs < 0 {
+		nargs = f.NArg() // any number of arguments
+	}
+	if f.NArg() > nargs {
+		fmt.Fprintln(os.Stderr, "unexpected arguments:", strings.Join(f.Args()[nargs:], " "))
+		return exitUsage, false
+	}
+	if f.NArg() < nargs {
+		fmt.Fprintf(os.Stderr, "expected %d arguments, got %d\n", nargs, f.NArg())
+		f.Usage()
+		return exitUsage, false
+	}
+	f.Visit(func(fl *flag.Flag) {
+		pf.set[fl.Name] = true
+	})
+	return exitOK, true
+}
+
+// loadProject reads the config and builds the project described by the flags
+func loadProject(pf *projectFlags) (*Project, error) {
+	err := c.Init(pf.ConfigPath)
 	if err != nil {
-		core.Panic("Failed parse config!!!")
+		return nil, fmt.Errorf("failed to parse config %s: %s", pf.ConfigPath, err)
 	}
 	core.Debug = c.General.Debug
 	core.Errors = c.General.Errors
 
-	p, err := NewProject()
+	var p Project
+	if pf.ProjectPath != "" {
+		p, err = LoadProjectFile(pf.ProjectPath)
+	} else {
+		p, err = NewProject()
+	}
 	if err != nil {
-		core.Eprint(err)
+		return nil, err
 	}
-	p.Name = "test"
-	p.DataDir = "/tmp/data/" + p.Name
-	p.Scope = core.Scope{
-		Ranges:   []string{"192.168.56.*"},
-		Excludes: []string{"192.168.56.11-250"},
+	pf.applyTo(&p)
+	if p.DataDir == "" && p.Name != "" && c.General.DataDir != "" {
+		p.DataDir = strings.TrimSuffix(c.General.DataDir, "/") + "/" + p.Name
 	}
-	p.RootDoms = []string{"test.com", "admin.test.com"}
-	p.MaxThreads = 5
-	p.ReconVars = core.VarMap{
+	p.Vars = core.VarMap{
 		"OutFile":      p.genOutfile,
 		"RootDomsCSV":  p.genRootDomsCSV,
 		"RootDomsFile": p.genRootDomsFile,
-		"IPFile":       p.exampleVarFunc,
+		"OutDir":       p.genOutputDir,
+		"IPFile":       p.genIPFile,
+		"DomsFile":     p.genDomsFile,
+		"DomsIPFile":   p.genAllFile,
+	}
+	for name, v := range c.Recon.Vars {
+		if _, ok := p.Vars[name]; ok {
+			return nil, errors.New("var " + name + " is already defined by webrecon, use another name")
+		}
+		p.Vars[name], err = p.configVar(name, v)
+		if err != nil {
+			return nil, err
+		}
 	}
-	p.ReconCallbacks = core.CallBacks{
+	p.CallBacks = core.CallBacks{
 		"domains": p.domainsCallback,
+		"aq":      p.aqCallback,
+	}
+	p.LineCallBacks = core.LineCallBacks{
+		"domains":  p.domainsLineCallback,
+		"progress": p.progressLineCallback,
+	}
+	p.Items = core.ItemMap{
+		"domains":  p.itemDomains,
+		"ips":      p.itemIPs,
+		"targets":  p.itemTargets,
+		"rootdoms": p.itemRootDoms,
+	}
+
+	err = validateProject(&p)
+	if err != nil {
+		return nil, err
 	}
+	return &p, nil
+}
 
-	p.FlyoverVars = core.VarMap{
-		"OutDir":     p.genOutputDir,
-		"IPFile":     p.genIPFile,
-		"DomsFile":   p.genDomsFile,
-		"DomsIPFile": p.genAllFile,
+// applyTo overrides the project values with the flags set on the command line
+func (pf *projectFlags) applyTo(p *Project) {
+	if pf.set["name"] {
+		p.Name = pf.Name
+	}
+	if pf.set["data"] {
+		p.DataDir = pf.DataDir
+	}
+	if pf.set["scope"] {
+		p.Scope.Ranges = splitList(pf.Ranges)
 	}
-	p.FlyoverCallbacks = core.CallBacks{
-		"aq": p.aqCallback,
+	if pf.set["exclude"] {
+		p.Scope.Excludes = splitList(pf.Excludes)
 	}
+	if pf.set["domains"] {
+		p.RootDoms = splitList(pf.RootDoms)
+	}
+	if pf.set["threads"] || p.MaxThreads == 0 {
+		p.MaxThreads = pf.Threads
+	}
+}
 
-	p.StartRecon()
+// splitList splits a comma separated flag value, dropping empty items
+func splitList(s string) []string {
+	var r []string
+	for _, i := range strings.Split(s, ",") {
+		i = strings.TrimSpace(i)
+		if i != "" {
+			r = append(r, i)
+		}
+	}
+	return r
+}
 
+func fail(a ...interface{}) int {
+	fmt.Fprintln(os.Stderr, append([]interface{}{"error:"}, a...)...)
+	return exitError
 }
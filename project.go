@@ -1,40 +1,78 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"webrecon/core"
+
+	"gopkg.in/yaml.v2"
 )
 
 type DNStoIPMap map[string][]string
 
+// Project describes an engagement, the exported fields with yaml tags can be loaded from a project file.
 type Project struct {
-	Name             string
-	Scope            core.Scope
-	RootDoms         []string
-	DNSMap           DNStoIPMap
-	DataDir          string
-	Targets          []string
-	ResultsPath      string
-	ReconVars        core.VarMap
-	ReconCallbacks   core.CallBacks
-	FlyoverVars      core.VarMap
-	FlyoverCallbacks core.CallBacks
-	MaxThreads       int
+	Name          string             `yaml:"name"`
+	Scope         core.Scope         `yaml:"scope"`
+	RootDoms      []string           `yaml:"root_domains"`
+	DNSMap        DNStoIPMap         `yaml:"-"`
+	DataDir       string             `yaml:"data_dir"`
+	Targets       []string           `yaml:"-"`
+	Ports         []core.Port        `yaml:"-"` // Ports, URLs and Findings are the results parsed from commands with a parse config
+	URLs          []string           `yaml:"-"`
+	Findings      []core.Finding     `yaml:"-"`
+	ResultsPath   string             `yaml:"-"`
+	Vars          core.VarMap        `yaml:"-"` // Vars holds every VarFunc a stage can bind to
+	CallBacks     core.CallBacks     `yaml:"-"` // CallBacks holds every callback a stage can bind to
+	LineCallBacks core.LineCallBacks `yaml:"-"` // LineCallBacks holds every streaming callback a stage can bind to
+	Items         core.ItemMap       `yaml:"-"` // Items holds every list a foreach command can be run for
+	MaxThreads    int                `yaml:"max_threads"`
+	Restart       bool               `yaml:"-"` // Restart ignores an interrupted run instead of resuming it
+	DryRun        bool               `yaml:"-"` // DryRun prints the commands StartRecon would run, see dryRunRecon
+	NoCache       bool               `yaml:"-"` // NoCache runs every command again instead of reusing cached results
+	state         projectState
+	journal       *core.Journal
+	cache         *core.Cache
+	tools         map[string]core.ToolInfo // registry tools found by the preflight check
+	runner        *core.CmdRunner          // runner of the current stage, for the control socket
+	paused        bool                     // paused by the control socket, applies to the stages that start later
+	mu            *sync.Mutex
 }
 
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+var validDomain = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*\.?$`)
+
 func validateProject(p *Project) error {
 	if p.Name == "" {
 		return errors.New("project requires name")
 	}
+	if !validName.MatchString(p.Name) {
+		return errors.New("invalid project name " + p.Name + ", use letters, numbers, '.', '_' and '-'")
+	}
 	if p.DataDir == "" {
 		return errors.New("no data directory specified")
 	}
 	if len(p.Scope.Ranges) <= 0 {
 		return errors.New("no scope specified (Scope.Ranges)")
 	}
+	err := p.Scope.Validate()
+	if err != nil {
+		return err
+	}
+	for _, dom := range p.RootDoms {
+		if !validDomain.MatchString(dom) {
+			return errors.New("invalid root domain " + dom)
+		}
+	}
+	if p.MaxThreads <= 0 {
+		return errors.New("MaxThreads must be greater than 0")
+	}
 	if !strings.HasSuffix(p.DataDir, "/") {
 		p.DataDir = p.DataDir + "/"
 	}
@@ -44,48 +82,274 @@ func validateProject(p *Project) error {
 func NewProject() (Project, error) {
 	var p Project
 	p.DNSMap = make(DNStoIPMap)
+	p.mu = new(sync.Mutex)
 	return p, nil
 }
 
-func (p *Project) StartRecon() error {
-	err := validateProject(p)
+// LoadProjectFile decodes a project file into a new Project, unknown keys are treated as errors.
+func LoadProjectFile(path string) (Project, error) {
+	p, err := NewProject()
+	if err != nil {
+		return p, err
+	}
+	b, err := os.ReadFile(path)
 	if err != nil {
-		core.Panic(err)
+		return p, err
 	}
+	err = yaml.UnmarshalStrict(b, &p)
+	if err != nil {
+		return p, fmt.Errorf("failed to parse project file %s: %s", path, err)
+	}
+	return p, nil
+}
 
-	p.mapHostnames()
+// WriteProjectFile writes the project definition to path, it will not overwrite an existing file.
+func (p *Project) WriteProjectFile(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.New(path + " already exists")
+	}
+	b, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
 
-	recontasks := core.NewCmdRunner()
-	recontasks.CallBacks = p.ReconCallbacks
+// StartRecon runs every configured stage in order, saving the project state in DataDir as it goes.
+// if the previous run was interrupted it is resumed, skipping commands that already completed successfully.
+// an error is returned if the project is invalid, or any of the commands failed.
+// cancelling ctx kills the running commands and leaves the run to be resumed later.
+func (p *Project) StartRecon(ctx context.Context) error {
+	err := validateProject(p)
+	if err != nil {
+		return err
+	}
+	if p.DryRun {
+		return p.dryRunRecon(os.Stdout)
+	}
+	if missing := core.MissingEnv(c.Recon.Vars, os.Getenv); len(missing) > 0 {
+		return errors.New("required environment variables are not set: " + strings.Join(missing, ", "))
+	}
+	p.tools, err = core.CheckTools(c.Recon.Registry)
+	if err != nil {
+		return err
+	}
+	stages := c.GetStages()
+	err = p.validateStages(stages)
+	if err != nil {
+		return err
+	}
+	err = core.MakeDir(p.DataDir)
+	if err != nil {
+		return err
+	}
+	err = p.resumeOrStart()
+	if err != nil {
+		return err
+	}
+	p.state.Tools = p.tools
+	err = p.SaveState()
+	if err != nil {
+		return err
+	}
+	p.journal, err = core.OpenJournal(p.journalPath())
+	if err != nil {
+		return err
+	}
+	p.journal.RunID = p.state.RunID
+	p.cache, err = core.NewCache(p.cachePath())
+	if err != nil {
+		return err
+	}
+	p.cache.Bypass = p.NoCache
+	ctl, err := p.serveControl()
+	if err != nil {
+		core.Eprint("control socket disabled:", err)
+	} else {
+		defer os.Remove(p.controlPath())
+		defer ctl.Close()
+	}
 
-	recontasks.VarMap = p.ReconVars
+	if !p.state.Mapped {
+		p.mapHostnames()
+		p.state.Mapped = true
+		p.SaveState()
+	}
 
-	recontasks.MaxThreads = p.MaxThreads
+	var failed []core.Cmd
+	for _, st := range stages {
+		f, err := p.runStage(ctx, st)
+		if err == context.Canceled {
+			return errors.New("run " + p.state.RunID + " interrupted in stage " + st.Name + ", run again to resume it")
+		}
+		if err != nil {
+			return errors.New("stage " + st.Name + ": " + err.Error())
+		}
+		failed = append(failed, f...)
+	}
 
-	err = recontasks.Run(c.Recon.TargetID)
+	p.state.Complete = true
+	err = p.SaveState()
 	if err != nil {
-		core.Eprint(err)
+		return err
+	}
+	if len(failed) > 0 {
+		var names []string
+		for _, i := range failed {
+			names = append(names, i.Name)
+		}
+		return fmt.Errorf("%d command(s) failed: %s", len(failed), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// stageBindings returns the VarFuncs, callbacks and line callbacks a stage is bound to.
+// every unknown name is reported in the error, the maps hold the names that were found.
+func (p *Project) stageBindings(st core.Stage) (core.VarMap, core.CallBacks, core.LineCallBacks, error) {
+	var unknown []string
+	vars := p.Vars
+	if len(st.Vars) > 0 {
+		vars = make(core.VarMap)
+		for _, name := range st.Vars {
+			vf, ok := p.Vars[name]
+			if !ok {
+				unknown = append(unknown, "unknown var "+name)
+				continue
+			}
+			vars[name] = vf
+		}
+	}
+	cbs := p.CallBacks
+	lcbs := p.LineCallBacks
+	if len(st.CallBacks) > 0 {
+		cbs = make(core.CallBacks)
+		lcbs = make(core.LineCallBacks)
+		for _, name := range st.CallBacks {
+			cb, ok := p.CallBacks[name]
+			if ok {
+				cbs[name] = cb
+			}
+			lcb, lok := p.LineCallBacks[name]
+			if lok {
+				lcbs[name] = lcb
+			}
+			if !ok && !lok {
+				unknown = append(unknown, "unknown callback "+name)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return vars, cbs, lcbs, errors.New("stage " + st.Name + ": " + strings.Join(unknown, ", "))
+	}
+	return vars, cbs, lcbs, nil
+}
+
+// validateStages checks the commands of every stage with its bindings before the first one starts,
+// so a bad template in a late stage does not wait for the earlier stages to run. the problems of all stages are reported together.
+func (p *Project) validateStages(stages core.Stages) error {
+	var problems []string
+	prior := make(core.StatusMap)
+	for _, st := range stages {
+		r, err := p.stageRunner(st)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		for k, v := range prior {
+			r.Prior[k] = v
+		}
+		err = r.Validate(st.Runners)
+		if err != nil {
+			problems = append(problems, "stage "+st.Name+": "+err.Error())
+		}
+		for _, i := range st.Runners {
+			prior[core.DepKey(st.Name, i.Name)] = core.StatusPlanned
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
 	}
+	return nil
+}
 
-	// start flyover
-	fr := core.NewCmdRunner()
-	fr.CallBacks = p.FlyoverCallbacks
+// stageRunner returns a new CmdRunner for a stage, bound to its vars and callbacks
+func (p *Project) stageRunner(st core.Stage) (*core.CmdRunner, error) {
+	vars, cbs, lcbs, err := p.stageBindings(st)
+	if err != nil {
+		return nil, err
+	}
+	r := core.NewCmdRunner()
+	r.VarMap = vars
+	r.CallBacks = cbs
+	r.LineCallBacks = lcbs
+	r.MaxThreads = p.MaxThreads
+	r.Pools = c.Recon.Pools
+	r.Items = p.Items
+	r.Tools = p.toolPaths()
+	if st.Concurrency > 0 {
+		r.MaxThreads = st.Concurrency
+	}
+	r.Name = st.Name
+	return r, nil
+}
 
-	fr.VarMap = p.FlyoverVars
-	fr.MaxThreads = p.MaxThreads
+// runStage runs the pending commands of a stage with a new CmdRunner, and returns the commands that failed.
+func (p *Project) runStage(ctx context.Context, st core.Stage) ([]core.Cmd, error) {
+	r, err := p.stageRunner(st)
+	if err != nil {
+		return nil, err
+	}
+	r.OnDone = p.cmdDone(st.Name)
+	r.OnResults = p.resultsCallback
+	r.Journal = p.journal
+	r.Cache = p.cache
+	r.Prior = p.priorStatus()
+	p.mu.Lock()
+	p.runner = r
+	if p.paused {
+		r.Pause()
+	}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.runner = nil
+		p.mu.Unlock()
+	}()
 
-	err = fr.RunWait(c.Recon.Flyover)
+	core.Dprint("starting stage", st.Name)
+	pending := p.pendingCmds(st.Name, st.Runners)
+	if st.Mode == core.ModeSequential {
+		err = r.RunWait(ctx, pending)
+	} else {
+		err = r.Run(ctx, pending)
+	}
 	if err != nil {
-		core.Eprint(err)
+		return nil, err
 	}
-	return nil
+	return r.Failed(), nil
+}
+
+// toolPaths maps the registry tools to the path they were found at, for {{ tool "name" }}
+func (p *Project) toolPaths() map[string]string {
+	m := make(map[string]string)
+	for name, t := range p.tools {
+		m[name] = t.Path
+	}
+	return m
+}
+
+func (p *Project) journalPath() string {
+	return p.DataDir + "journal.jsonl"
+}
+
+func (p *Project) cachePath() string {
+	return p.DataDir + "cache"
 }
 
 func (p *Project) mapHostnames() {
 	var wgDoms = new(sync.WaitGroup)
 	var wgDomCnt int
 	const maxResolves = 5
-	var mutex = &sync.Mutex{}
 	ips := p.Scope.GetInScopeIPs()
 	wgDomCnt = 0
 
@@ -102,9 +366,9 @@ func (p *Project) mapHostnames() {
 			if err == nil {
 				for _, dom := range hosts {
 					dom = strings.TrimSuffix(dom, ".")
-					mutex.Lock()
+					p.mu.Lock()
 					p.DNSMap[dom] = append(p.DNSMap[dom], ip)
-					mutex.Unlock()
+					p.mu.Unlock()
 				}
 			}
 			wgDomCnt--
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+	"webrecon/core"
+)
+
+// cmdState is the saved record of a completed Cmd
+type cmdState struct {
+	Stage      string         `json:"stage"`
+	Name       string         `json:"name"`
+	CmdLine    string         `json:"cmdline"`
+	Status     string         `json:"status"`
+	OutputFile string         `json:"output_file"`
+	Finished   time.Time      `json:"finished"`
+	Attempts   []core.Attempt `json:"attempts,omitempty"`
+}
+
+// projectState is the on disk format of the project, it is saved to DataDir after every completed command
+// so an interrupted run can be resumed.
+type projectState struct {
+	RunID    string                   `json:"run_id"`
+	Name     string                   `json:"name"`
+	Started  time.Time                `json:"started"`
+	Updated  time.Time                `json:"updated"`
+	Complete bool                     `json:"complete"` // Complete is false while a run is in progress or was interrupted
+	Mapped   bool                     `json:"mapped"`   // Mapped is true once the in scope IPs were reverse resolved
+	DNSMap   DNStoIPMap               `json:"dns_map"`
+	Targets  []string                 `json:"targets"`
+	Ports    []core.Port              `json:"ports,omitempty"`
+	URLs     []string                 `json:"urls,omitempty"`
+	Findings []core.Finding           `json:"findings,omitempty"`
+	Tools    map[string]core.ToolInfo `json:"tools,omitempty"` // Tools are the registry tools the run used, with their versions
+	Cmds     map[string]cmdState      `json:"cmds"`
+}
+
+func (p *Project) statePath() string {
+	return p.DataDir + "state.json"
+}
+
+// SaveState writes the project state to DataDir, replacing the previous state file, and updates the
+// snapshot of the current run in the run history.
+func (p *Project) SaveState() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state.Name = p.Name
+	p.state.Updated = time.Now()
+	p.state.DNSMap = p.DNSMap
+	p.state.Targets = core.UniqueSlice(p.Targets)
+	p.state.Ports = p.Ports
+	p.state.URLs = p.URLs
+	p.state.Findings = p.Findings
+	b, err := json.MarshalIndent(p.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(p.statePath(), b)
+	if err != nil {
+		return err
+	}
+	err = core.MakeDir(p.runsDir())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.runPath(p.state.RunID), b)
+}
+
+// writeFileAtomic writes to a temporary file, and renames it over path so readers never see a partial file
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadState reads the project state saved by a previous run into the project.
+func (p *Project) LoadState() error {
+	b, err := os.ReadFile(p.statePath())
+	if err != nil {
+		return err
+	}
+	var s projectState
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	p.state = s
+	p.Targets = s.Targets
+	p.DNSMap = s.DNSMap
+	p.Ports = s.Ports
+	p.URLs = s.URLs
+	p.Findings = s.Findings
+	if p.DNSMap == nil {
+		p.DNSMap = make(DNStoIPMap)
+	}
+	return nil
+}
+
+// resumeOrStart loads the state of an interrupted run, or starts a new one.
+func (p *Project) resumeOrStart() error {
+	if !p.Restart {
+		err := p.LoadState()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && !p.state.Complete && p.state.RunID != "" {
+			core.Dprint("resuming run", p.state.RunID, "with", len(p.state.Cmds), "completed commands")
+			return nil
+		}
+	}
+	now := time.Now()
+	p.state = projectState{RunID: p.newRunID(now), Started: now, Cmds: make(map[string]cmdState)}
+	p.DNSMap = make(DNStoIPMap)
+	p.Targets = nil
+	p.Ports = nil
+	p.URLs = nil
+	p.Findings = nil
+	return p.SaveState()
+}
+
+func stateKey(stage, name string) string {
+	return core.DepKey(stage, name)
+}
+
+// priorStatus returns the Status of every Cmd completed in the current run, used to resolve depends_on.
+func (p *Project) priorStatus() core.StatusMap {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := make(core.StatusMap)
+	for k, s := range p.state.Cmds {
+		m[k] = s.Status
+	}
+	return m
+}
+
+// pendingCmds returns the Cmds of a stage that have not completed successfully in the current run.
+func (p *Project) pendingCmds(stage string, r core.Runners) core.Runners {
+	var ret core.Runners
+	for _, i := range r {
+		if s, ok := p.state.Cmds[stateKey(stage, i.Name)]; ok && s.Status == core.StatusSuccess {
+			core.Dprint("skipping", i.Name, "already completed at", s.Finished)
+			continue
+		}
+		ret = append(ret, i)
+	}
+	return ret
+}
+
+// cmdDone returns a CmdRunner.OnDone hook which records completed Cmds and saves the state.
+func (p *Project) cmdDone(stage string) func(c core.Cmd) {
+	return func(c core.Cmd) {
+		p.mu.Lock()
+		p.state.Cmds[stateKey(stage, c.Name)] = cmdState{
+			Stage:      stage,
+			Name:       c.Name,
+			CmdLine:    c.CmdLine,
+			Status:     c.Status,
+			OutputFile: c.OutputFile,
+			Finished:   time.Now(),
+			Attempts:   c.Attempts,
+		}
+		p.mu.Unlock()
+		err := p.SaveState()
+		if err != nil {
+			core.Eprint("failed to save state:", err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"webrecon/core"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	p := &Project{Name: "p1", DataDir: t.TempDir() + "/", mu: new(sync.Mutex)}
+	if err := p.resumeOrStart(); err != nil {
+		t.Fatal(err)
+	}
+	run := p.state.RunID
+	p.DNSMap["a.com"] = []string{"10.0.0.1"}
+	p.Targets = []string{"10.0.0.1", "a.com", "10.0.0.1"}
+	p.Ports = []core.Port{{Host: "a.com", Port: 443, Proto: "tcp"}}
+	p.URLs = []string{"https://a.com/"}
+	p.Findings = []core.Finding{{Cmd: "nuclei", Target: "https://a.com/", Name: "git", Severity: "high"}}
+	p.cmdDone("s1")(core.Cmd{Name: "amass", CmdLine: "amass enum", Status: core.StatusSuccess, OutputFile: "/tmp/out",
+		Attempts: []core.Attempt{{Attempt: 1, Status: core.StatusError}, {Attempt: 2, Status: core.StatusSuccess}}})
+	p.cmdDone("s1")(core.Cmd{Name: "nmap", Status: core.StatusError})
+
+	// an interrupted run is resumed with what it found
+	q := &Project{Name: "p1", DataDir: p.DataDir, mu: new(sync.Mutex)}
+	if err := q.resumeOrStart(); err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(q.state.RunID, q.DNSMap, q.Targets, q.Ports, q.URLs, q.Findings)
+	want := fmt.Sprint(run, p.DNSMap, []string{"10.0.0.1", "a.com"}, p.Ports, p.URLs, p.Findings)
+	if got != want {
+		t.Errorf("resumed\n%s\nwant\n%s", got, want)
+	}
+	amass := q.state.Cmds["s1/amass"]
+	if amass.CmdLine != "amass enum" || amass.OutputFile != "/tmp/out" || len(amass.Attempts) != 2 || amass.Finished.IsZero() {
+		t.Errorf("saved cmd %+v", amass)
+	}
+	prior := q.priorStatus()
+	if len(prior) != 2 || prior["s1/amass"] != core.StatusSuccess || prior["s1/nmap"] != core.StatusError {
+		t.Errorf("prior status %v", prior)
+	}
+	// only the commands that did not succeed are run again
+	pending := q.pendingCmds("s1", core.Runners{{Name: "amass"}, {Name: "nmap"}, {Name: "new"}})
+	if len(pending) != 2 || pending[0].Name != "nmap" || pending[1].Name != "new" {
+		t.Errorf("pending %v", pending)
+	}
+
+	// a complete run is not resumed, nor is one with restart set
+	q.state.Complete = true
+	if err := q.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	for _, restart := range []bool{false, true} {
+		r := &Project{Name: "p1", DataDir: p.DataDir, Restart: restart, mu: new(sync.Mutex)}
+		if err := r.resumeOrStart(); err != nil {
+			t.Fatal(err)
+		}
+		if r.state.RunID == run || len(r.Targets) != 0 || len(r.DNSMap) != 0 || len(r.state.Cmds) != 0 {
+			t.Errorf("restart %v: run %s targets %v cmds %v, want a new run", restart, r.state.RunID, r.Targets, r.state.Cmds)
+		}
+		run = r.state.RunID
+	}
+	if _, err := os.Stat(p.statePath() + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary state file was left behind")
+	}
+}